@@ -1,27 +1,49 @@
 package repository
 
 import (
+	"bitbucket.org/pkg/inflect"
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"reflect"
 )
 
 type Repository interface {
-	FindOneBy(filters map[string]interface{}) (interface{}, error)
-	FindBy(filters map[string]interface{}) ([]interface{}, error)
 	Find(id int64) (interface{}, error)
+	FindOneBy(filters map[string]interface{}, orderBy []Order) (interface{}, error)
+	FindBy(filters map[string]interface{}, orderBy []Order, limit int, offset int) ([]interface{}, error)
+	FindAll() ([]interface{}, error)
+	Count(filters map[string]interface{}) (int64, error)
 	Save(packet interface{}) (int64, error)
+	Update(packet interface{}) error
+	Delete(packet interface{}) error
 }
 
+// Repository с передачей context.Context в запросы к БД
+type ContextRepository interface {
+	FindCtx(ctx context.Context, id int64) (interface{}, error)
+	FindOneByCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order) (interface{}, error)
+	FindByCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order, limit int, offset int) ([]interface{}, error)
+	FindAllCtx(ctx context.Context) ([]interface{}, error)
+	CountCtx(ctx context.Context, filters map[string]interface{}) (int64, error)
+	SaveCtx(ctx context.Context, packet interface{}) (int64, error)
+	UpdateCtx(ctx context.Context, packet interface{}) error
+	DeleteCtx(ctx context.Context, packet interface{}) error
+}
+
+var _ Repository = (*AbstractRepo)(nil)
+var _ ContextRepository = (*AbstractRepo)(nil)
+
 type AbstractRepo struct {
-	db          *sql.DB
+	db          Executor
 	config      *TableConfig
 	reflectType reflect.Type
-	//identityMap *identityMap
-	qb *QueryBuilder
+	session     *Session
+	qb          *QueryBuilder
 }
 
-func NewAbstractRepo(db *sql.DB, config *TableConfig, reflectType reflect.Type) *AbstractRepo {
+func NewAbstractRepo(db Executor, config *TableConfig, reflectType reflect.Type) *AbstractRepo {
 	return &AbstractRepo{db: db, config: config, reflectType: reflectType, qb: &QueryBuilder{}}
 }
 
@@ -30,130 +52,120 @@ type RowScanner interface {
 }
 
 func (a *AbstractRepo) Update(packet interface{}) error {
+	return a.UpdateCtx(context.Background(), packet)
+}
+
+func (a *AbstractRepo) UpdateCtx(ctx context.Context, packet interface{}) error {
+
+	// Со снимком загруженных значений обновляются только изменённые колонки
+	columns := a.updateColumns(packet)
+	if columns != nil && len(columns) == 0 {
+		return nil
+	}
 
 	//logger.Debug(fmt.Sprint("Update object of type ", reflect.TypeOf(packet), ": ", packet))
-	sql := a.qb.Update(a.config, packet)
+	sql, args, err := a.qb.UpdateColumns(a.config, packet, columns)
+	if errors.Is(err, ErrNothingToUpdate) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 	//logger.DebugSQL(sql)
 
-	saveResult := a.db.QueryRow(sql)
-	//fmt.Println("saveResult", saveResult.LastInsertId(), "error", error)
-	if saveResult == nil {
-		return errors.New("Error occured")
+	result, err := a.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return writeError(a.config, err)
+	}
+
+	if a.config.Version != "" {
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		versionField := reflect.Indirect(reflect.ValueOf(packet)).FieldByName(a.versionFieldName())
+		if affected == 0 {
+			return fmt.Errorf("%w: table %s, id %v, version %v", ErrStaleEntity, a.config.TableName,
+				reflect.Indirect(reflect.ValueOf(packet)).FieldByName(a.pkFieldName()).Interface(), versionField.Interface())
+		}
+
+		// При откате транзакции в сущность возвращается версия, которая есть в БД
+		previous := reflect.New(versionField.Type()).Elem()
+		previous.Set(versionField)
+		if tx, ok := a.db.(*Tx); ok {
+			tx.onRollback(func() {
+				versionField.Set(previous)
+			})
+		}
+
+		switch versionField.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			versionField.SetInt(versionField.Int() + 1)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			versionField.SetUint(versionField.Uint() + 1)
+		}
 	}
 
+	a.rememberWrittenSnapshot(packet)
+
 	return nil
 }
 
 func (a *AbstractRepo) Save(packet interface{}) (int64, error) {
+	return a.SaveCtx(context.Background(), packet)
+}
 
-	for colName, colCfg := range a.config.TableColumns {
-		if colName == "id" {
-			t := reflect.Indirect(reflect.ValueOf(packet))
-			pk := t.FieldByName("ID").Interface()
-
-			exists := false
-			var pkVal int64
-			switch colCfg.Type {
-			case "int":
-				if pk.(int) > 0 {
-					pkVal = int64(pk.(int))
-					exists = true
-				}
-				break
-			case "int2":
-				if pk.(int16) > 0 {
-					pkVal = int64(pk.(int16))
-					exists = true
-				}
-				break
-			case "int4":
-				if pk.(int32) > 0 {
-					pkVal = int64(pk.(int32))
-					exists = true
-				}
-				break
-			case "int8":
-				if pk.(int64) > 0 {
-					pkVal = pk.(int64)
-					exists = true
-				}
-				break
-			}
-
-			if exists {
+func (a *AbstractRepo) SaveCtx(ctx context.Context, packet interface{}) (int64, error) {
 
-				return pkVal, a.Update(packet)
-			}
-		}
+	// Запись с заполненным первичным ключом обновляется, иначе вставляется
+	if id, ok := a.pkValue(packet); ok && id > 0 {
+		return id, a.UpdateCtx(ctx, packet)
 	}
 
 	//logger.Debug(fmt.Sprint("Insert object of type ", reflect.TypeOf(packet), ": ", packet))
-	sql := a.qb.Insert(a.config, packet)
+	sql, args, err := a.qb.Insert(a.config, packet)
+	if err != nil {
+		return 0, err
+	}
 	//logger.DebugSQL(sql)
 
-	saveResult := a.db.QueryRow(sql)
+	saveResult := a.db.QueryRowContext(ctx, sql, args...)
 	//fmt.Println("saveResult", saveResult.LastInsertId(), "error", error)
 	if saveResult == nil {
 		return 0, errors.New("Error occured")
 	}
 
 	var lastInsertId int64
-	err := saveResult.Scan(&lastInsertId)
+	err = saveResult.Scan(&lastInsertId)
 
 	if err != nil {
-		return 0, err
-	}
-
-	pkFieldName := a.config.PK
-	if pkFieldName == "id" {
-		pkFieldName = "ID"
+		return 0, writeError(a.config, err)
 	}
 
-	if _, ok := a.reflectType.FieldByName(pkFieldName); ok {
-		v := reflect.ValueOf(packet)
-		v = reflect.Indirect(v)
-		packetId := v.FieldByName(pkFieldName).Interface()
-		//fmt.Println("LAST N", lastInsertId)
-		switch a.config.TableColumns[a.config.PK].Type {
-		case "int":
-			{
-				if packetId.(int) == 0 {
-					v.FieldByName(pkFieldName).SetInt(lastInsertId)
-				}
-			}
-		case "int2":
-			{
-				if packetId.(int16) == 0 {
-					v.FieldByName(pkFieldName).SetInt(lastInsertId)
-				}
-			}
-		case "int4":
-			{
-				if packetId.(int32) == 0 {
-					v.FieldByName(pkFieldName).SetInt(lastInsertId)
-					//fmt.Println("LAST N", lastInsertId)
-				}
-			}
-		case "int8":
-			{
-				if packetId.(int64) == 0 {
-					v.FieldByName(pkFieldName).SetInt(lastInsertId)
-				}
-			}
+	if id, ok := a.pkValue(packet); ok && id == 0 {
+		if err := setInt(reflect.Indirect(reflect.ValueOf(packet)).FieldByName(a.pkFieldName()), lastInsertId); err != nil {
+			return 0, err
 		}
 	}
 
+	a.rememberWrittenSnapshot(packet)
+
 	//fmt.Println(saveResult)
 	//sql := fmt.Sprintf("INSERT INTO %s (\"" + strings.Join(tableColumnLabels, "\", \"" + "\") VALUES ("))
 	return lastInsertId, nil
 }
 
 func (a *AbstractRepo) Find(id int64) (interface{}, error) {
+	return a.FindCtx(context.Background(), id)
+}
+
+func (a *AbstractRepo) FindCtx(ctx context.Context, id int64) (interface{}, error) {
 
-	sql := a.qb.SelectById(a.config, a.reflectType, id)
+	sql, args := a.qb.SelectById(a.config, a.reflectType, id)
 	//logger.DebugSQL(sql)
 
-	fetchResult := a.db.QueryRow(sql)
+	fetchResult := a.db.QueryRowContext(ctx, sql, args...)
 
 	if fetchResult.Err() != nil {
 		return nil, fetchResult.Err()
@@ -161,18 +173,28 @@ func (a *AbstractRepo) Find(id int64) (interface{}, error) {
 
 	object := reflect.New(a.reflectType).Interface()
 
-	a.fillRecordData(object, a.config, fetchResult)
+	err := a.fillRecordData(ctx, object, a.config, fetchResult)
+	if err != nil {
+		return nil, a.notFoundError(err)
+	}
 
 	//sql := fmt.Sprintf("INSERT INTO %s (\"" + strings.Join(tableColumnLabels, "\", \"" + "\") VALUES ("))
-	return object, nil
+	return a.managed(object), nil
 }
 
-func (a *AbstractRepo) FindOneBy(filters map[string]interface{}, asc bool) (interface{}, error) {
-	sql := a.qb.SelectBy(a.config, a.reflectType, filters, 1, 0, asc)
+func (a *AbstractRepo) FindOneBy(filters map[string]interface{}, orderBy []Order) (interface{}, error) {
+	return a.FindOneByCtx(context.Background(), filters, orderBy)
+}
+
+func (a *AbstractRepo) FindOneByCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order) (interface{}, error) {
+	sql, args, err := a.qb.SelectBy(a.config, a.reflectType, filters, 1, 0, orderBy)
+	if err != nil {
+		return nil, err
+	}
 
 	//logger.DebugSQL(sql)
 
-	fetchResult := a.db.QueryRow(sql)
+	fetchResult := a.db.QueryRowContext(ctx, sql, args...)
 
 	if fetchResult.Err() != nil {
 		return nil, fetchResult.Err()
@@ -180,23 +202,32 @@ func (a *AbstractRepo) FindOneBy(filters map[string]interface{}, asc bool) (inte
 
 	object := reflect.New(a.reflectType).Interface()
 
-	err := a.fillRecordData(object, a.config, fetchResult)
+	err = a.fillRecordData(ctx, object, a.config, fetchResult)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			return nil, nil
-		}
-		return nil, err
+		return nil, a.notFoundError(err)
 	}
 
-	return object, nil
+	return a.managed(object), nil
+}
+
+func (a *AbstractRepo) FindBy(filters map[string]interface{}, orderBy []Order, limit int, offset int) ([]interface{}, error) {
+	return a.FindByCtx(context.Background(), filters, orderBy, limit, offset)
+}
+
+func (a *AbstractRepo) FindByCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order, limit int, offset int) ([]interface{}, error) {
+	return a.findByCtx(ctx, filters, nil, orderBy, limit, offset)
 }
 
-func (a *AbstractRepo) FindBy(filters map[string]interface{}, asc bool) ([]interface{}, error) {
-	sql := a.qb.SelectBy(a.config, a.reflectType, filters, 0, 0, asc)
+// FindByCtx с условием keyset-пагинации
+func (a *AbstractRepo) findByCtx(ctx context.Context, filters map[string]interface{}, keyset *keysetExpr, orderBy []Order, limit int, offset int) ([]interface{}, error) {
+	sql, args, err := a.qb.selectBy(a.config, a.reflectType, filters, keyset, limit, offset, orderBy)
+	if err != nil {
+		return nil, err
+	}
 
 	//logger.DebugSQL(sql)
 
-	fetchResult, err := a.db.Query(sql)
+	fetchResult, err := a.db.QueryContext(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -207,47 +238,251 @@ func (a *AbstractRepo) FindBy(filters map[string]interface{}, asc bool) ([]inter
 		return []interface{}{}, fetchResult.Err()
 	}
 
-	result, err := a.fillRecordsData(a.config, fetchResult)
+	result, err := a.fillRecordsData(ctx, a.config, fetchResult)
 
-	return result, err
+	if err != nil {
+		return result, err
+	}
+
+	for i, object := range result {
+		result[i] = a.managed(object)
+	}
+
+	return result, nil
 }
 
 func (a *AbstractRepo) FindAll() ([]interface{}, error) {
+	return a.FindAllCtx(context.Background())
+}
+
+func (a *AbstractRepo) FindAllCtx(ctx context.Context) ([]interface{}, error) {
 	filtersDummy := make(map[string]interface{})
-	return a.FindBy(filtersDummy, true)
+	return a.FindByCtx(ctx, filtersDummy, nil, 0, 0)
+}
+
+// Deprecated: используйте FindOneBy(filters, OrderByPK(cfg, asc))
+func (a *AbstractRepo) FindOneByAsc(filters map[string]interface{}, asc bool) (interface{}, error) {
+	return a.FindOneBy(filters, OrderByPK(a.config, asc))
+}
+
+// Deprecated: используйте FindBy(filters, OrderByPK(cfg, asc), 0, 0)
+func (a *AbstractRepo) FindByAsc(filters map[string]interface{}, asc bool) ([]interface{}, error) {
+	return a.FindBy(filters, OrderByPK(a.config, asc), 0, 0)
+}
+
+// Страница выборки вместе с общим количеством записей по тем же фильтрам
+func (a *AbstractRepo) FindPage(filters map[string]interface{}, orderBy []Order, page Page) (*PageResult, error) {
+	return a.FindPageCtx(context.Background(), filters, orderBy, page)
+}
+
+func (a *AbstractRepo) FindPageCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order, page Page) (*PageResult, error) {
+	if err := page.validate(); err != nil {
+		return nil, err
+	}
+
+	total, err := a.CountCtx(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PageResult{Items: []interface{}{}, Total: total, Number: page.Number, Size: page.Size}
+	if int64(page.Offset()) >= total {
+		return result, nil
+	}
+
+	result.Items, err = a.FindByCtx(ctx, filters, orderBy, page.Size, page.Offset())
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Keyset-пагинация: до limit записей после курсора (пустой курсор - первая страница) и курсор следующей страницы.
+// К сортировке добавляется первичный ключ; курсор следующей страницы пустой, если записей больше нет
+func (a *AbstractRepo) FindAfter(filters map[string]interface{}, cursor string, limit int, orderBy ...Order) ([]interface{}, string, error) {
+	return a.FindAfterCtx(context.Background(), filters, cursor, limit, orderBy...)
+}
+
+func (a *AbstractRepo) FindAfterCtx(ctx context.Context, filters map[string]interface{}, cursor string, limit int, orderBy ...Order) ([]interface{}, string, error) {
+	if limit < 1 {
+		return nil, "", errors.New("Limit must be greater than 0")
+	}
+
+	orders, columns, err := a.qb.keysetOrder(a.config, a.reflectType, orderBy)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var keyset *keysetExpr
+	if cursor != "" {
+		values, err := decodeKeysetCursor(a.config, cursor, columns)
+		if err != nil {
+			return nil, "", err
+		}
+
+		desc := []bool{}
+		for _, order := range orders {
+			desc = append(desc, order.Desc)
+		}
+
+		keyset = &keysetExpr{columns: columns, desc: desc, values: values}
+	}
+
+	// Лишняя запись показывает, есть ли следующая страница
+	items, err := a.findByCtx(ctx, filters, keyset, orders, limit+1, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(items) <= limit {
+		return items, "", nil
+	}
+	items = items[:limit]
+
+	next, err := encodeKeysetCursor(a.config, items[len(items)-1], columns)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return items, next, nil
+}
+
+func (a *AbstractRepo) Count(filters map[string]interface{}) (int64, error) {
+	return a.CountCtx(context.Background(), filters)
+}
+
+func (a *AbstractRepo) CountCtx(ctx context.Context, filters map[string]interface{}) (int64, error) {
+	sql, args, err := a.qb.Count(a.config, a.reflectType, filters)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err = a.db.QueryRowContext(ctx, sql, args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (a *AbstractRepo) Delete(packet interface{}) error {
+	return a.DeleteCtx(context.Background(), packet)
+}
+
+func (a *AbstractRepo) DeleteCtx(ctx context.Context, packet interface{}) error {
+	sql, args, err := a.qb.Delete(a.config, packet)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.db.ExecContext(ctx, sql, args...)
+
+	return writeError(a.config, err)
+}
+
+func (a *AbstractRepo) DeleteById(id int64) error {
+	return a.DeleteByIdCtx(context.Background(), id)
+}
+
+func (a *AbstractRepo) DeleteByIdCtx(ctx context.Context, id int64) error {
+	sql, args := a.qb.DeleteById(a.config, id)
+
+	_, err := a.db.ExecContext(ctx, sql, args...)
+
+	return writeError(a.config, err)
+}
+
+// Удаляет записи по фильтрам SelectBy и возвращает количество удалённых записей. Пустые фильтры - ErrInvalidFilter
+func (a *AbstractRepo) DeleteBy(filters map[string]interface{}) (int64, error) {
+	return a.DeleteByCtx(context.Background(), filters)
+}
+
+func (a *AbstractRepo) DeleteByCtx(ctx context.Context, filters map[string]interface{}) (int64, error) {
+	sql, args, err := a.qb.DeleteBy(a.config, a.reflectType, filters)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := a.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return 0, writeError(a.config, err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Удаляет все записи таблицы и возвращает количество удалённых записей
+func (a *AbstractRepo) DeleteAll() (int64, error) {
+	return a.DeleteAllCtx(context.Background())
+}
+
+func (a *AbstractRepo) DeleteAllCtx(ctx context.Context) (int64, error) {
+	sql, args, err := a.qb.DeleteAll(a.config, a.reflectType)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := a.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return 0, writeError(a.config, err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Копия репозитория, выполняющая запросы в транзакции tx
+func (a *AbstractRepo) WithTx(tx Executor) *AbstractRepo {
+	repo := *a
+	repo.db = tx
+
+	return &repo
+}
+
+// Копия репозитория, выборки которой не исключают записи, помеченные как удалённые
+func (a *AbstractRepo) IncludeDeleted() *AbstractRepo {
+	repo := *a
+	repo.qb = &QueryBuilder{IncludeDeleted: true}
+
+	return &repo
 }
 
-func (a *AbstractRepo) fillRecordsData(cfg *TableConfig, rows *sql.Rows) ([]interface{}, error) {
+func (a *AbstractRepo) fillRecordsData(ctx context.Context, cfg *TableConfig, rows *sql.Rows) ([]interface{}, error) {
 	result := []interface{}{}
+	keys := []map[string]sql.NullInt64{}
 
-	rows.Scan()
 	for rows.Next() {
-		//fmt.Println(reflect.New(t).Elem().Interface())
-		//fmt.Println(reflect.ValueOf(reflect.New(t).Elem().Interface()).Type())
-		//os.Exit(0)
 		object := reflect.New(a.reflectType).Interface()
-		//object2 := &codec8.Tracker{}
-		//fmt.Printf("%T\n", object)
-		//fmt.Printf("%T\n", object2)
 
-		err := a.fillRecordData(object, cfg, rows)
+		objectKeys, err := a.scanRecordData(object, cfg, rows)
 
 		if err != nil {
 			return []interface{}{}, err
 		}
 		result = append(result, object)
+		keys = append(keys, objectKeys)
+	}
+
+	if err := rows.Err(); err != nil {
+		return []interface{}{}, err
+	}
+
+	err := a.fillRecordsDataRelations(ctx, result, keys, cfg)
+	if err != nil {
+		return []interface{}{}, err
 	}
 
 	return result, nil
 }
 
-func (a *AbstractRepo) fillRecordData(object interface{}, cfg *TableConfig, row RowScanner) error {
-	err := a.fillRecordDataFields(object, cfg, row)
+func (a *AbstractRepo) fillRecordData(ctx context.Context, object interface{}, cfg *TableConfig, row RowScanner) error {
+	keys, err := a.scanRecordData(object, cfg, row)
 	if err != nil {
 		return err
 	}
 
-	err = a.fillRecordDataRelations(object, cfg, row)
+	err = a.fillRecordsDataRelations(ctx, []interface{}{object}, []map[string]sql.NullInt64{keys}, cfg)
 	if err != nil {
 		return err
 	}
@@ -255,147 +490,292 @@ func (a *AbstractRepo) fillRecordData(object interface{}, cfg *TableConfig, row
 	return nil
 }
 
-func (a *AbstractRepo) fillRecordDataRelations(object interface{}, cfg *TableConfig, row RowScanner) error {
+// Заполняет поля записи и возвращает значения внешних ключей связей one_to_one/many_to_one
+func (a *AbstractRepo) scanRecordData(object interface{}, cfg *TableConfig, row RowScanner, extra ...interface{}) (map[string]sql.NullInt64, error) {
+	relKeys := GetTableRelationKeys(cfg, reflect.TypeOf(object))
 
-	return nil
-	t := reflect.TypeOf(object)
+	keyValues := make([]sql.NullInt64, len(relKeys))
+	dest := []interface{}{}
+	for i := range keyValues {
+		dest = append(dest, &keyValues[i])
+	}
+
+	err := a.fillRecordDataFields(object, cfg, row, append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]sql.NullInt64)
+	for i, relName := range relKeys {
+		keys[relName] = keyValues[i]
+	}
+
+	return keys, nil
+}
+
+// Загружает связи для всех записей выборки: по одному запросу с IN на каждую связь
+func (a *AbstractRepo) fillRecordsDataRelations(ctx context.Context, objects []interface{}, keys []map[string]sql.NullInt64, cfg *TableConfig) error {
+
+	if len(objects) == 0 || len(cfg.Relations) == 0 {
+		return nil
+	}
+
+	t := reflect.TypeOf(objects[0])
 	//fmt.Println("TTT1", t, object)
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
 
-	//fmt.Println("TTT2", t)
-
 	tableRelationFields, _ := GetTableRelationMap(cfg, t)
 
-	for relName, relCfg := range a.config.Relations {
-		classField, ok := t.FieldByName(tableRelationFields[relName])
+	for _, relName := range cfg.RelationsArr {
+		relCfg := cfg.Relations[relName]
+		fieldName, ok := tableRelationFields[relName]
 		if !ok {
-			return errors.New("Field not found for relation " + relName + " in type " + t.Name())
+			continue
 		}
 
-		classField = classField
+		classField, _ := t.FieldByName(fieldName)
 
+		var err error
 		switch relCfg.Type {
-		case "one_to_one":
-			break
-		case "many_to_one":
+		case "one_to_one", "many_to_one":
+			err = a.fillToOneRelation(ctx, objects, keys, cfg, relName, classField)
 			break
 		case "one_to_many":
+			err = a.fillToManyRelation(ctx, objects, cfg, relName, classField)
 			break
 		}
+
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (a *AbstractRepo) fillRecordDataFields(object interface{}, cfg *TableConfig, row RowScanner) error {
+func (a *AbstractRepo) fillToOneRelation(ctx context.Context, objects []interface{}, keys []map[string]sql.NullInt64, cfg *TableConfig, relName string, classField reflect.StructField) error {
+	relCfg := cfg.Relations[relName]
+	if _, ok := relCfg.Params["foreign_key"]; !ok {
+		return errors.New("Foreign key is not set for relation " + relName + " in table " + cfg.TableName)
+	}
 
-	t := reflect.TypeOf(object)
-	//fmt.Println("TTT1", t, object)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
+	targetType := classField.Type
+	if targetType.Kind() == reflect.Ptr {
+		targetType = targetType.Elem()
 	}
 
-	//fmt.Println("TTT2", t)
+	ids := []int64{}
+	idsAdded := make(map[int64]bool)
+	for _, objectKeys := range keys {
+		key := objectKeys[relName]
+		if key.Valid && !idsAdded[key.Int64] {
+			idsAdded[key.Int64] = true
+			ids = append(ids, key.Int64)
+		}
+	}
 
-	tableColumnFields, _ := GetTableColumnMap(cfg, t)
-	fieldValuesArr := []interface{}{}
-	fieldValuesArrCallbacks := []func() (interface{}, string, string){}
+	if len(ids) == 0 {
+		return nil
+	}
 
-	for _, colName := range a.config.TableColumnsArr {
-		colCfg := a.config.TableColumns[colName]
-		classField, ok := t.FieldByName(tableColumnFields[colName])
+	targetCfg, err := relationTargetConfig(cfg, relName, targetType)
+	if err != nil {
+		return err
+	}
+
+	targetRepo := a.relationRepo(targetCfg, targetType)
+
+	filters := map[string]interface{}{targetCfg.PK: &IN{Ids: ids}}
+	query, args, err := targetRepo.qb.SelectBy(targetCfg, targetType, filters, 0, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	targets := make(map[int64]reflect.Value)
+	for rows.Next() {
+		target := reflect.New(targetType)
+		_, err := targetRepo.scanRecordData(target.Interface(), targetCfg, rows)
+		if err != nil {
+			return err
+		}
+
+		id, _ := targetRepo.pkValue(target.Interface())
+		targets[id] = reflect.ValueOf(targetRepo.managed(target.Interface()))
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, object := range objects {
+		key := keys[i][relName]
+		if !key.Valid {
+			continue
+		}
+
+		target, ok := targets[key.Int64]
 		if !ok {
-			return errors.New("Field not found for column " + colName + " in type " + t.Name())
+			continue
 		}
 
-		switch colCfg.Type {
-		case "string":
+		field := reflect.Indirect(reflect.ValueOf(object)).FieldByName(classField.Name)
+		if classField.Type.Kind() == reflect.Ptr {
+			field.Set(target)
+		} else {
+			field.Set(target.Elem())
+		}
+	}
 
-			val := sql.NullString{}
-			fieldValuesArr = append(fieldValuesArr, &val)
-			fieldValuesArrCallbacks = append(fieldValuesArrCallbacks, func() (interface{}, string, string) {
-				return val.String, classField.Name, classField.Type.Name()
-			})
-			break
-		case "float64":
+	return nil
+}
 
-			val := sql.NullFloat64{}
-			fieldValuesArr = append(fieldValuesArr, &val)
-			fieldValuesArrCallbacks = append(fieldValuesArrCallbacks, func() (interface{}, string, string) {
-				return val.Float64, classField.Name, classField.Type.Name()
-			})
-			break
-		case "int":
-
-			if classField.Type.Name() == "uint8" {
-				val := sql.NullInt16{}
-				fieldValuesArr = append(fieldValuesArr, &val)
-				fieldValuesArrCallbacks = append(fieldValuesArrCallbacks, func() (interface{}, string, string) {
-					return val.Int16, classField.Name, classField.Type.Name()
-				})
-			} else {
-				val := sql.NullByte{}
-				fieldValuesArr = append(fieldValuesArr, &val)
-				fieldValuesArrCallbacks = append(fieldValuesArrCallbacks, func() (interface{}, string, string) {
-					return val.Byte, classField.Name, classField.Type.Name()
-				})
-			}
-			break
-		case "int2":
-
-			if classField.Type.Name() == "uint16" {
-				val := sql.NullInt32{}
-				fieldValuesArr = append(fieldValuesArr, &val)
-				fieldValuesArrCallbacks = append(fieldValuesArrCallbacks, func() (interface{}, string, string) {
-					return val.Int32, classField.Name, classField.Type.Name()
-				})
+func (a *AbstractRepo) fillToManyRelation(ctx context.Context, objects []interface{}, cfg *TableConfig, relName string, classField reflect.StructField) error {
+	relCfg := cfg.Relations[relName]
+	fk, ok := relCfg.Params["foreign_key"]
+	if !ok {
+		return errors.New("Foreign key is not set for relation " + relName + " in table " + cfg.TableName)
+	}
+
+	if classField.Type.Kind() != reflect.Slice {
+		return errors.New("Field " + classField.Name + " for relation " + relName + " must be a slice")
+	}
+
+	targetType := classField.Type.Elem()
+	if targetType.Kind() == reflect.Ptr {
+		targetType = targetType.Elem()
+	}
+
+	ids := []interface{}{}
+	for _, object := range objects {
+		id, _ := a.pkValue(object)
+		ids = append(ids, id)
+	}
+
+	targetCfg, err := relationTargetConfig(cfg, relName, targetType)
+	if err != nil {
+		return err
+	}
+
+	targetRepo := a.relationRepo(targetCfg, targetType)
+
+	query, args := targetRepo.qb.SelectByRelation(targetCfg, targetType, fk.(string), ids)
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	targets := make(map[int64][]reflect.Value)
+	for rows.Next() {
+		target := reflect.New(targetType)
+		parentKey := sql.NullInt64{}
+		_, err := targetRepo.scanRecordData(target.Interface(), targetCfg, rows, &parentKey)
+		if err != nil {
+			return err
+		}
+
+		if parentKey.Valid {
+			targets[parentKey.Int64] = append(targets[parentKey.Int64], reflect.ValueOf(targetRepo.managed(target.Interface())))
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		value := reflect.Indirect(reflect.ValueOf(object))
+		items := reflect.MakeSlice(classField.Type, 0, 0)
+		id, _ := a.pkValue(object)
+		for _, target := range targets[id] {
+			if classField.Type.Elem().Kind() == reflect.Ptr {
+				items = reflect.Append(items, target)
 			} else {
-				val := sql.NullInt16{}
-				fieldValuesArr = append(fieldValuesArr, &val)
-				fieldValuesArrCallbacks = append(fieldValuesArrCallbacks, func() (interface{}, string, string) {
-					return val.Int16, classField.Name, classField.Type.Name()
-				})
+				items = reflect.Append(items, target.Elem())
 			}
+		}
 
-			break
-		case "int4":
+		value.FieldByName(classField.Name).Set(items)
+	}
 
-			val := sql.NullInt32{}
-			fieldValuesArr = append(fieldValuesArr, &val)
-			fieldValuesArrCallbacks = append(fieldValuesArrCallbacks, func() (interface{}, string, string) {
-				return val.Int32, classField.Name, classField.Type.Name()
-			})
-			break
-		case "int8":
+	return nil
+}
 
-			val := sql.NullInt64{}
-			fieldValuesArr = append(fieldValuesArr, &val)
-			fieldValuesArrCallbacks = append(fieldValuesArrCallbacks, func() (interface{}, string, string) {
-				return val.Int64, classField.Name, classField.Type.Name()
-			})
-			break
-		case "bool":
+// Репозиторий цели связи с теми же соединением и построителем запросов
+func (a *AbstractRepo) relationRepo(targetCfg *TableConfig, targetType reflect.Type) *AbstractRepo {
+	repo := NewAbstractRepo(a.db, targetCfg, targetType)
+	repo.qb = a.qb
+	repo.session = a.session
 
-			val := sql.NullBool{}
-			fieldValuesArr = append(fieldValuesArr, &val)
-			fieldValuesArrCallbacks = append(fieldValuesArrCallbacks, func() (interface{}, string, string) {
-				return val.Bool, classField.Name, classField.Type.Name()
-			})
-			break
-		}
-		//reflect.ValueOf(object).FieldByName(tableColumnFields[colName])
+	return repo
+}
 
+// sql.ErrNoRows превращается в ErrNotFound с именем таблицы
+func (a *AbstractRepo) notFoundError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: table %s", ErrNotFound, a.config.TableName)
 	}
 
-	err := row.Scan(fieldValuesArr...)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return err
-		} else {
-			panic(err)
+	return err
+}
+
+func (a *AbstractRepo) versionFieldName() string {
+	fields, _ := GetTableColumnMap(a.config, a.reflectType)
+
+	return fields[a.config.Version]
+}
+
+func (a *AbstractRepo) pkFieldName() string {
+	fields, _ := GetTableColumnMap(a.config, a.reflectType)
+	if fieldName, ok := fields[a.config.PK]; ok {
+		return fieldName
+	}
+
+	return inflect.Camelize(a.config.PK)
+}
+
+// Значение целочисленного первичного ключа сущности
+func (a *AbstractRepo) pkValue(object interface{}) (int64, bool) {
+	return intFieldValue(reflect.ValueOf(object), a.pkFieldName())
+}
+
+func (a *AbstractRepo) fillRecordDataFields(object interface{}, cfg *TableConfig, row RowScanner, extra ...interface{}) error {
+
+	t := reflect.TypeOf(object)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	tableColumnFields, _ := GetTableColumnMap(cfg, t)
+	fieldValuesArr := []interface{}{}
+	fieldValuesArrCallbacks := []func(value reflect.Value){}
+
+	for _, colName := range cfg.TableColumnsArr {
+		classField, ok := t.FieldByName(tableColumnFields[colName])
+		if !ok {
+			return fmt.Errorf("%w: column %s of table %s in type %s", ErrFieldNotMapped, colName, cfg.TableName, t.Name())
 		}
 
+		dest := &fieldScanner{colCfg: cfg.TableColumns[colName], fieldType: classField.Type}
+		fieldValuesArr = append(fieldValuesArr, dest)
+		fieldValuesArrCallbacks = append(fieldValuesArrCallbacks, func(value reflect.Value) {
+			value.FieldByName(classField.Name).Set(dest.value)
+		})
+	}
+
+	err := row.Scan(append(fieldValuesArr, extra...)...)
+	if err != nil {
+		return err
 	}
 
 	value := reflect.ValueOf(object)
@@ -404,51 +784,9 @@ func (a *AbstractRepo) fillRecordDataFields(object interface{}, cfg *TableConfig
 		value = value.Elem()
 	}
 
-	tmp := reflect.New(value.Type()).Elem()
-	//fmt.Println("TTT3", tmp)
-	//fmt.Println("TTT4", value)
-	tmp.Set(value)
-	//fmt.Println("TTT5", tmp)
 	for _, callback := range fieldValuesArrCallbacks {
-		val, classFieldName, Type := callback()
-		//fmt.Println(val, classFieldName, Type)
-		switch Type {
-		case "string":
-			tmp.FieldByName(classFieldName).SetString(val.(string))
-			break
-		case "float64":
-			tmp.FieldByName(classFieldName).SetFloat(val.(float64))
-			break
-		case "int":
-			tmp.FieldByName(classFieldName).SetInt(int64(val.(int32)))
-			break
-		case "uint8":
-			tmp.FieldByName(classFieldName).SetUint(uint64(val.(int16)))
-			break
-		case "uint16":
-			tmp.FieldByName(classFieldName).SetUint(uint64(val.(int32)))
-			break
-		case "int16":
-			tmp.FieldByName(classFieldName).SetInt(int64(val.(int16)))
-			break
-		case "int32":
-			tmp.FieldByName(classFieldName).SetInt(int64(val.(int32)))
-			break
-		case "int64":
-			tmp.FieldByName(classFieldName).SetInt(val.(int64))
-			break
-		case "bool":
-			tmp.FieldByName(classFieldName).SetBool(val.(bool))
-			break
-		}
-
-		//value := reflect.ValueOf(object).FieldByName(classFieldName)
+		callback(value)
 	}
 
-	value.Set(tmp)
-
-	//fmt.Println("value.Interface()", value.Interface())
-	//fmt.Println("object.Interface()", object)
-
 	return nil
 }
@@ -0,0 +1,419 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type versionedDoc struct {
+	ID      int64  `repo:"pk"`
+	Title   string `repo:""`
+	Version int64  `repo:"version"`
+}
+
+func TestUpdateVersionRestoredOnRetry(t *testing.T) {
+	f, db := newFakeDb(t)
+	ctx := context.Background()
+
+	repo, err := NewTypedRepoFromStruct[versionedDoc](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := false
+	f.fail = func(query string, args []interface{}) error {
+		if query == "COMMIT" && !failed {
+			failed = true
+			return &fakePqError{Code: "40001"}
+		}
+		return nil
+	}
+
+	doc := &versionedDoc{ID: 1, Title: "a", Version: 3}
+	err = RunInTx(ctx, db, func(tx *Tx) error {
+		return repo.WithTx(tx).UpdateCtx(ctx, doc)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Version != 4 {
+		t.Errorf("expected version 4 after commit, got %d", doc.Version)
+	}
+
+	updates := [][]interface{}{}
+	for i, query := range f.queries {
+		if query == f.queriesWithPrefix("UPDATE")[0] {
+			updates = append(updates, f.args[i])
+		}
+	}
+	if len(updates) != 2 || !reflect.DeepEqual(updates[0], updates[1]) {
+		t.Errorf("retried UPDATE must use the same version, got %v", updates)
+	}
+}
+
+func TestUpdateVersionRestoredOnRollback(t *testing.T) {
+	_, db := newFakeDb(t)
+	ctx := context.Background()
+
+	repo, err := NewTypedRepoFromStruct[versionedDoc](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := &versionedDoc{ID: 1, Title: "a", Version: 3}
+	rollback := errors.New("rollback")
+	err = RunInTx(ctx, db, func(tx *Tx) error {
+		if err := repo.WithTx(tx).UpdateCtx(ctx, doc); err != nil {
+			return err
+		}
+		if doc.Version != 4 {
+			t.Errorf("expected version 4 inside transaction, got %d", doc.Version)
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("expected rollback error, got %v", err)
+	}
+
+	if doc.Version != 3 {
+		t.Errorf("expected version 3 after rollback, got %d", doc.Version)
+	}
+}
+
+func TestUpdateStaleVersion(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[versionedDoc](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.affected = func(query string, args []interface{}) int64 { return 0 }
+
+	doc := &versionedDoc{ID: 1, Title: "a", Version: 3}
+	if err := repo.Update(doc); !errors.Is(err, ErrStaleEntity) {
+		t.Errorf("expected ErrStaleEntity, got %v", err)
+	}
+	if doc.Version != 3 {
+		t.Errorf("stale update changed version to %d", doc.Version)
+	}
+}
+
+func TestFindNotFound(t *testing.T) {
+	_, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[versionedDoc](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Find(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSaveUniqueViolation(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	// Собственная конфигурация: общие из кэша не изменяются
+	cfg, err := structTableConfig(reflect.TypeOf(versionedDoc{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Constraints["versioned_docs_title_key"] = "headline"
+
+	f.fail = func(query string, args []interface{}) error {
+		return &fakePqError{Code: "23505", Constraint: "versioned_docs_title_key"}
+	}
+
+	repo := NewTypedRepoWithConfig[versionedDoc](db, cfg)
+	_, err = repo.Save(&versionedDoc{Title: "a"})
+
+	var violation *ConstraintViolation
+	if !errors.As(err, &violation) || !errors.Is(err, ErrUniqueViolation) {
+		t.Fatalf("expected unique violation, got %v", err)
+	}
+	if violation.Field != "headline" {
+		t.Errorf("expected field headline, got %q", violation.Field)
+	}
+}
+
+type intIdEntity struct {
+	ID   int    `repo:"pk"`
+	Name string `repo:""`
+}
+
+type lowerIdEntity struct {
+	Id   int32  `repo:"pk"`
+	Name string `repo:""`
+}
+
+type codeEntity struct {
+	Code uint64 `repo:"pk"`
+	Name string `repo:""`
+}
+
+func TestSavePrimaryKeyFields(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	intRepo := NewTypedRepoWithConfig[intIdEntity](db, mustStructConfig(t, intIdEntity{}))
+	lowerRepo := NewTypedRepoWithConfig[lowerIdEntity](db, mustStructConfig(t, lowerIdEntity{}))
+	codeRepo := NewTypedRepoWithConfig[codeEntity](db, mustStructConfig(t, codeEntity{}))
+
+	f.addRows([]string{"id"}, []driver.Value{int64(5)})
+	f.addRows([]string{"id"}, []driver.Value{int64(6)})
+	f.addRows([]string{"code"}, []driver.Value{int64(7)})
+
+	intEntity := &intIdEntity{Name: "a"}
+	lowerEntity := &lowerIdEntity{Name: "b"}
+	code := &codeEntity{Name: "c"}
+	for _, save := range []func() (int64, error){
+		func() (int64, error) { return intRepo.Save(intEntity) },
+		func() (int64, error) { return lowerRepo.Save(lowerEntity) },
+		func() (int64, error) { return codeRepo.Save(code) },
+	} {
+		if _, err := save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if intEntity.ID != 5 || lowerEntity.Id != 6 || code.Code != 7 {
+		t.Fatalf("primary keys not set after insert: %d, %d, %d", intEntity.ID, lowerEntity.Id, code.Code)
+	}
+
+	if id, err := codeRepo.Save(code); err != nil || id != 7 {
+		t.Fatalf("Save of existing entity: %d, %v", id, err)
+	}
+
+	last := f.queries[len(f.queries)-1]
+	if last != `UPDATE "code_entities" SET "name" = $1 WHERE "code" = $2` {
+		t.Errorf("expected UPDATE by code, got %s", last)
+	}
+
+	f.addRows([]string{"code", "name"}, []driver.Value{int64(7), "c"})
+	if _, err := codeRepo.Find(7); err != nil {
+		t.Fatal(err)
+	}
+	if last := f.queries[len(f.queries)-1]; last != `SELECT "code", "name" FROM "code_entities" WHERE "code" = $1` {
+		t.Errorf("unexpected Find query %s", last)
+	}
+}
+
+func mustStructConfig(t *testing.T, entity interface{}) *TableConfig {
+	t.Helper()
+
+	cfg, err := TableConfigFromStruct(reflect.TypeOf(entity))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func TestFindAfterLastPage(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[versionedDoc](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []string{"id", "title", "version"}
+	f.addRows(columns, []driver.Value{int64(1), "a", int64(1)}, []driver.Value{int64(2), "b", int64(1)}, []driver.Value{int64(3), "c", int64(1)})
+	items, cursor, err := repo.FindAfter(nil, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 || cursor == "" {
+		t.Fatalf("expected 2 items and a cursor, got %d, %q", len(items), cursor)
+	}
+	if !strings.HasSuffix(f.queries[0], "LIMIT 3") {
+		t.Errorf("expected limit+1 query, got %s", f.queries[0])
+	}
+
+	f.addRows(columns, []driver.Value{int64(3), "c", int64(1)})
+	items, next, err := repo.FindAfter(nil, cursor, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 1 || items[0].ID != 3 || next != "" {
+		t.Errorf("expected last page with empty cursor, got %d items, %q", len(items), next)
+	}
+	if !reflect.DeepEqual(f.args[1], []interface{}{int64(2)}) {
+		t.Errorf("unexpected keyset args %v", f.args[1])
+	}
+
+	f.addRows(columns, []driver.Value{int64(1), "a", int64(1)}, []driver.Value{int64(2), "b", int64(1)})
+	if _, cursor, err := repo.FindAfter(nil, "", 2); err != nil || cursor != "" {
+		t.Errorf("exactly limit rows left must return empty cursor, got %q, %v", cursor, err)
+	}
+
+	if _, _, err := repo.FindAfter(nil, "garbage", 2); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+type relAccount struct {
+	Code uint64 `repo:"pk"`
+	Name string `repo:""`
+}
+
+type relComment struct {
+	ID   int64  `repo:"pk"`
+	Text string `repo:""`
+}
+
+type relPost struct {
+	ID       int64        `repo:"pk"`
+	Title    string       `repo:""`
+	Author   *relAccount  `repo:"rel=many_to_one,fk=author_code"`
+	Comments []relComment `repo:"rel=one_to_many,fk=post_id"`
+}
+
+func TestFindLoadsRelations(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[relPost](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.addRows([]string{"id", "title", "author_code"}, []driver.Value{int64(1), "p", int64(9)})
+	f.addRows([]string{"code", "name"}, []driver.Value{int64(9), "a"})
+	f.addRows([]string{"id", "text", "post_id"}, []driver.Value{int64(3), "x", int64(1)}, []driver.Value{int64(4), "y", int64(1)})
+
+	post, err := repo.Find(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if post.Author == nil || post.Author.Code != 9 || post.Author.Name != "a" {
+		t.Errorf("many_to_one relation not loaded: %+v", post.Author)
+	}
+
+	if !reflect.DeepEqual(post.Comments, []relComment{{ID: 3, Text: "x"}, {ID: 4, Text: "y"}}) {
+		t.Errorf("one_to_many relation not loaded: %+v", post.Comments)
+	}
+
+	expected := []string{
+		`SELECT "m0_"."code", "m0_"."name" FROM "rel_accounts" AS "m0_"  WHERE "m0_"."code" IN ($1) ORDER BY "m0_"."code" ASC `,
+		`SELECT "m0_"."id", "m0_"."text", "m0_"."post_id" FROM "rel_comments" AS "m0_" WHERE "m0_"."post_id" IN ($1) ORDER BY "m0_"."id" ASC`,
+	}
+	if len(f.queries) != 3 || !reflect.DeepEqual(f.queries[1:], expected) {
+		t.Errorf("unexpected relation queries: %q", f.queries)
+	}
+	if !reflect.DeepEqual(f.args[1:], [][]interface{}{{int64(9)}, {int64(1)}}) {
+		t.Errorf("unexpected relation arguments: %v", f.args)
+	}
+}
+
+func TestFindByLoadsRelationsOnce(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[relPost](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.addRows([]string{"id", "title", "author_code"},
+		[]driver.Value{int64(1), "p", int64(9)}, []driver.Value{int64(2), "q", int64(9)}, []driver.Value{int64(3), "r", nil})
+	f.addRows([]string{"code", "name"}, []driver.Value{int64(9), "a"})
+	f.addRows([]string{"id", "text", "post_id"}, []driver.Value{int64(5), "z", int64(2)})
+
+	posts, err := repo.FindBy(nil, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posts) != 3 || posts[0].Author == nil || posts[0].Author != posts[1].Author || posts[2].Author != nil {
+		t.Fatalf("unexpected authors: %+v", posts)
+	}
+
+	if len(posts[0].Comments) != 0 || len(posts[1].Comments) != 1 || posts[1].Comments[0].ID != 5 || posts[0].Comments == nil {
+		t.Errorf("unexpected comments: %+v %+v", posts[0].Comments, posts[1].Comments)
+	}
+
+	if len(f.queries) != 3 || !reflect.DeepEqual(f.args[1], []interface{}{int64(9)}) || !reflect.DeepEqual(f.args[2], []interface{}{int64(1), int64(2), int64(3)}) {
+		t.Errorf("expected one query per relation: %q %v", f.queries, f.args)
+	}
+}
+
+func TestFindAfterWithFilters(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[versionedDoc](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.addRows([]string{"id", "title", "version"}, []driver.Value{int64(1), "a", int64(1)}, []driver.Value{int64(2), "a", int64(1)})
+	_, cursor, err := repo.FindAfter(Filters{"Title": "a"}, "", 1, Desc("Title"))
+	if err != nil || cursor == "" {
+		t.Fatalf("expected cursor, got %q, %v", cursor, err)
+	}
+
+	f.addRows([]string{"id", "title", "version"})
+	if _, _, err := repo.FindAfter(Filters{"Title": "a"}, cursor, 1, Desc("Title")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT "m0_"."id", "m0_"."title", "m0_"."version" FROM "versioned_docs" AS "m0_"  ` +
+		`WHERE "m0_"."title" = $1 AND ("m0_"."title", "m0_"."id") < ($2, $3) ORDER BY "m0_"."title" DESC, "m0_"."id" DESC  LIMIT 2`
+	if f.queries[1] != expected || !reflect.DeepEqual(f.args[1], []interface{}{"a", "a", int64(1)}) {
+		t.Errorf("unexpected keyset query:\n%s\n%v", f.queries[1], f.args[1])
+	}
+
+	if _, _, err := repo.FindAfter(Filters{"\x00keyset": "a"}, "", 1); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("expected ErrInvalidFilter for unknown key, got %v", err)
+	}
+}
+
+func TestFindPage(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[versionedDoc](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.addRows([]string{"count"}, []driver.Value{int64(5)})
+	f.addRows([]string{"id", "title", "version"}, []driver.Value{int64(3), "a", int64(1)}, []driver.Value{int64(4), "a", int64(1)})
+
+	page, err := repo.FindPage(Filters{"Title": "a"}, nil, Page{Number: 2, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Total != 5 || page.Pages() != 3 || len(page.Items) != 2 || page.Items[0].ID != 3 || page.Number != 2 || page.Size != 2 {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	expected := []string{
+		`SELECT COUNT(*) FROM "versioned_docs" AS "m0_"  WHERE "m0_"."title" = $1`,
+		`SELECT "m0_"."id", "m0_"."title", "m0_"."version" FROM "versioned_docs" AS "m0_"  WHERE "m0_"."title" = $1 ORDER BY "m0_"."id" ASC  LIMIT 2 OFFSET 2`,
+	}
+	if !reflect.DeepEqual(f.queries, expected) {
+		t.Errorf("unexpected page queries: %q", f.queries)
+	}
+
+	f.addRows([]string{"count"}, []driver.Value{int64(4)})
+	page, err = repo.FindPage(nil, nil, Page{Number: 3, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Total != 4 || len(page.Items) != 0 || page.Items == nil || len(f.queries) != 3 {
+		t.Errorf("page after the last must not select rows: %+v, %q", page, f.queries)
+	}
+
+	if _, err := repo.FindPage(nil, nil, Page{Number: 0, Size: 2}); err == nil {
+		t.Errorf("expected error for page number 0")
+	}
+}
@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Тип колонки-массива задаётся как тип элемента с суффиксом [], например string[] или int8[].
+// Массивы передаются и читаются в текстовом формате Postgres, поддерживаются только одномерные массивы
+func arrayElementType(typeStr string) (string, bool) {
+	if !strings.HasSuffix(typeStr, "[]") {
+		return "", false
+	}
+
+	return strings.TrimSuffix(typeStr, "[]"), true
+}
+
+// Приёмник для колонки-массива: срез элементов заполняется по типу элемента колонки, NULL-элемент - нулевое значение
+func arrayScanner(elemType string) (interface{}, func(field reflect.Value) error) {
+	var val []byte
+	return &val, func(field reflect.Value) error {
+		if field.Kind() != reflect.Slice {
+			return fieldTypeError(field, elemType+"[]")
+		}
+
+		if val == nil {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+
+		items, err := parseArrayLiteral(string(val))
+		if err != nil {
+			return err
+		}
+
+		result := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			var src interface{}
+			if item != nil {
+				if src, err = arrayItemValue(elemType, *item); err != nil {
+					return err
+				}
+			}
+
+			scanner := &fieldScanner{colCfg: &TableColumnConfig{Type: elemType, Nullable: true}, fieldType: field.Type().Elem()}
+			if err := scanner.Scan(src); err != nil {
+				return err
+			}
+
+			result.Index(i).Set(scanner.value)
+		}
+
+		field.Set(result)
+
+		return nil
+	}
+}
+
+// Значение для колонки-массива: текстовый литерал массива. nil-срез в nullable-колонке записывается как NULL
+func (qb *QueryBuilder) arrayValueForSQL(elemType string, value interface{}, nullable bool) (interface{}, error) {
+	v := reflect.ValueOf(value)
+	if value == nil || (v.Kind() == reflect.Slice && v.IsNil()) {
+		if nullable {
+			return nil, nil
+		}
+		return "{}", nil
+	}
+
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("Value of type %T can not be written to column of type %s[]", value, elemType)
+	}
+
+	items := []string{}
+	for i := 0; i < v.Len(); i++ {
+		item, err := qb.prepareValueForSQL(elemType, v.Index(i).Interface(), false, false)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, arrayItemLiteral(elemType, item))
+	}
+
+	return "{" + strings.Join(items, ",") + "}", nil
+}
+
+func arrayItemLiteral(elemType string, value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "t"
+		}
+		return "f"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return quoteArrayItem(v.Format(time.RFC3339Nano))
+	case []byte:
+		if elemType == "bytea" {
+			return quoteArrayItem("\\x" + hex.EncodeToString(v))
+		}
+		return quoteArrayItem(string(v))
+	}
+
+	return quoteArrayItem(fmt.Sprint(value))
+}
+
+// Форматы даты и времени Postgres в текстовом представлении элементов массивов
+var arrayTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00:00",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// Значение элемента массива для Scan: время и bytea разбираются из текстового представления,
+// остальные типы database/sql преобразует из строки сам
+func arrayItemValue(elemType string, item string) (interface{}, error) {
+	switch elemType {
+	case "timestamp", "timestamptz", "date":
+		for _, layout := range arrayTimeLayouts {
+			if t, err := time.Parse(layout, item); err == nil {
+				return t, nil
+			}
+		}
+		return nil, errors.New("Invalid " + elemType + " array element: " + item)
+	case "bytea":
+		if !strings.HasPrefix(item, "\\x") {
+			return nil, errors.New("Unsupported bytea array element format: " + item)
+		}
+		return hex.DecodeString(item[2:])
+	}
+
+	return item, nil
+}
+
+func quoteArrayItem(s string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s) + "\""
+}
+
+// Элементы текстового литерала одномерного массива; nil - элемент NULL
+func parseArrayLiteral(s string) ([]*string, error) {
+	// Необязательные границы размерности: [0:2]={...}
+	if strings.HasPrefix(s, "[") {
+		if i := strings.Index(s, "="); i != -1 {
+			s = s[i+1:]
+		}
+	}
+
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return nil, errors.New("Invalid array literal: " + s)
+	}
+
+	body := s[1 : len(s)-1]
+	items := []*string{}
+	if body == "" {
+		return items, nil
+	}
+
+	for i := 0; i <= len(body); {
+		if i < len(body) && body[i] == '{' {
+			return nil, errors.New("Multidimensional arrays are not supported: " + s)
+		}
+
+		var item strings.Builder
+		quoted := false
+		if i < len(body) && body[i] == '"' {
+			quoted = true
+			i++
+			for ; i < len(body) && body[i] != '"'; i++ {
+				if body[i] == '\\' && i+1 < len(body) {
+					i++
+				}
+				item.WriteByte(body[i])
+			}
+
+			if i >= len(body) {
+				return nil, errors.New("Invalid array literal: " + s)
+			}
+			i++
+		} else {
+			for ; i < len(body) && body[i] != ','; i++ {
+				item.WriteByte(body[i])
+			}
+		}
+
+		if i < len(body) && body[i] != ',' {
+			return nil, errors.New("Invalid array literal: " + s)
+		}
+
+		value := item.String()
+		if !quoted {
+			value = strings.TrimSpace(value)
+		}
+
+		if !quoted && strings.EqualFold(value, "NULL") {
+			items = append(items, nil)
+		} else {
+			items = append(items, &value)
+		}
+
+		i++
+	}
+
+	return items, nil
+}
+
+// Аргумент-массив для выражений фильтров, кодируется в литерал при выполнении запроса
+type arrayArg struct {
+	values interface{}
+}
+
+func (a arrayArg) Value() (driver.Value, error) {
+	return (&QueryBuilder{}).arrayValueForSQL("", a.values, false)
+}
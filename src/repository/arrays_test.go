@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func scanColumn(t *testing.T, typeStr string, fieldType reflect.Type, src interface{}) reflect.Value {
+	t.Helper()
+
+	scanner := &fieldScanner{colCfg: &TableColumnConfig{Type: typeStr, Nullable: true}, fieldType: fieldType}
+	if err := scanner.Scan(src); err != nil {
+		t.Fatalf("scan %v into %s: %v", src, fieldType, err)
+	}
+
+	return scanner.value
+}
+
+func TestArrayScan(t *testing.T) {
+	strs := scanColumn(t, "string[]", reflect.TypeOf([]string{}), []byte(`{a,"b c","d\"e",NULL}`)).Interface()
+	if !reflect.DeepEqual(strs, []string{"a", "b c", "d\"e", ""}) {
+		t.Errorf("string[] = %#v", strs)
+	}
+
+	ints := scanColumn(t, "int8[]", reflect.TypeOf([]int64{}), "{1,2,3}").Interface()
+	if !reflect.DeepEqual(ints, []int64{1, 2, 3}) {
+		t.Errorf("int8[] = %#v", ints)
+	}
+
+	bools := scanColumn(t, "bool[]", reflect.TypeOf([]bool{}), "{t,f}").Interface()
+	if !reflect.DeepEqual(bools, []bool{true, false}) {
+		t.Errorf("bool[] = %#v", bools)
+	}
+
+	times := scanColumn(t, "timestamptz[]", reflect.TypeOf([]time.Time{}),
+		`{"2024-01-02 03:04:05.5+00","2024-01-03 00:00:00+03:30"}`).Interface().([]time.Time)
+	expected := []time.Time{
+		time.Date(2024, 1, 2, 3, 4, 5, 500000000, time.UTC),
+		time.Date(2024, 1, 2, 20, 30, 0, 0, time.UTC),
+	}
+	if len(times) != 2 || !times[0].Equal(expected[0]) || !times[1].Equal(expected[1]) {
+		t.Errorf("timestamptz[] = %v", times)
+	}
+
+	dates := scanColumn(t, "date[]", reflect.TypeOf([]time.Time{}), "{2024-01-02}").Interface().([]time.Time)
+	if len(dates) != 1 || !dates[0].Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("date[] = %v", dates)
+	}
+
+	blobs := scanColumn(t, "bytea[]", reflect.TypeOf([][]byte{}), `{"\\x6869",NULL}`).Interface()
+	if !reflect.DeepEqual(blobs, [][]byte{[]byte("hi"), nil}) {
+		t.Errorf("bytea[] = %#v", blobs)
+	}
+
+	if null := scanColumn(t, "string[]", reflect.TypeOf([]string{}), nil); !null.IsNil() {
+		t.Errorf("NULL array must scan to nil slice")
+	}
+}
+
+func TestArrayValueForSQL(t *testing.T) {
+	qb := &QueryBuilder{}
+
+	cases := []struct {
+		typeStr  string
+		value    interface{}
+		nullable bool
+		expected interface{}
+	}{
+		{"string[]", []string{"a", "b\"c", `d\e`}, false, `{"a","b\"c","d\\e"}`},
+		{"int8[]", []int64{1, 2}, false, "{1,2}"},
+		{"bool[]", []bool{true, false}, false, "{t,f}"},
+		{"float64[]", []float64{1.5}, false, "{1.5}"},
+		{"timestamptz[]", []time.Time{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}, false, `{"2024-01-02T03:04:05Z"}`},
+		{"bytea[]", [][]byte{[]byte("hi")}, false, `{"\\x6869"}`},
+		{"string[]", []string(nil), false, "{}"},
+		{"string[]", []string(nil), true, nil},
+	}
+
+	for _, c := range cases {
+		value, err := qb.prepareValueForSQL(c.typeStr, c.value, c.nullable, false)
+		if err != nil {
+			t.Fatalf("%s %v: %v", c.typeStr, c.value, err)
+		}
+
+		if !reflect.DeepEqual(value, c.expected) {
+			t.Errorf("%s %v = %#v, expected %#v", c.typeStr, c.value, value, c.expected)
+		}
+	}
+
+	if _, err := qb.prepareValueForSQL("string[]", 5, false, false); err == nil {
+		t.Errorf("expected error for non-slice array value")
+	}
+}
+
+func TestParseArrayLiteralErrors(t *testing.T) {
+	for _, literal := range []string{"", "{a", `{"a}`, "{{1,2},{3,4}}"} {
+		if _, err := parseArrayLiteral(literal); err == nil {
+			t.Errorf("expected error for %q", literal)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+var decimalType = reflect.TypeOf(Decimal{})
+var uuidType = reflect.TypeOf([16]byte{})
+
+// Приёмник для Scan по типу колонки и функция, переносящая прочитанное значение в поле сущности.
+// NULL переносится как нулевое значение поля
+func columnScanner(colCfg *TableColumnConfig) (interface{}, func(field reflect.Value) error) {
+	if elemType, ok := arrayElementType(colCfg.Type); ok {
+		return arrayScanner(elemType)
+	}
+
+	switch colCfg.Type {
+	case "string":
+		val := sql.NullString{}
+		return &val, func(field reflect.Value) error {
+			return setString(field, val.String)
+		}
+	case "float4", "float64":
+		val := sql.NullFloat64{}
+		return &val, func(field reflect.Value) error {
+			return setFloat(field, val.Float64)
+		}
+	case "int", "int2", "int4", "int8":
+		val := sql.NullInt64{}
+		return &val, func(field reflect.Value) error {
+			return setInt(field, val.Int64)
+		}
+	case "bool":
+		val := sql.NullBool{}
+		return &val, func(field reflect.Value) error {
+			if field.Kind() != reflect.Bool {
+				return fieldTypeError(field, "bool")
+			}
+			field.SetBool(val.Bool)
+			return nil
+		}
+	case "timestamp", "timestamptz", "date":
+		val := sql.NullTime{}
+		return &val, func(field reflect.Value) error {
+			if field.Type() != timeType {
+				return fieldTypeError(field, colCfg.Type)
+			}
+			field.Set(reflect.ValueOf(val.Time))
+			return nil
+		}
+	case "numeric":
+		val := sql.NullString{}
+		return &val, func(field reflect.Value) error {
+			if !val.Valid {
+				field.Set(reflect.Zero(field.Type()))
+				return nil
+			}
+
+			switch {
+			case field.Type() == decimalType:
+				d, err := NewDecimal(val.String)
+				if err != nil {
+					return err
+				}
+				field.Set(reflect.ValueOf(d))
+				return nil
+			case field.Kind() == reflect.Float32 || field.Kind() == reflect.Float64:
+				f, err := strconv.ParseFloat(val.String, 64)
+				if err != nil {
+					return err
+				}
+				return setFloat(field, f)
+			}
+
+			return setString(field, val.String)
+		}
+	case "uuid":
+		val := sql.NullString{}
+		return &val, func(field reflect.Value) error {
+			if field.Type() == uuidType {
+				if !val.Valid {
+					field.Set(reflect.Zero(uuidType))
+					return nil
+				}
+
+				u, err := parseUUID(val.String)
+				if err != nil {
+					return err
+				}
+				field.Set(reflect.ValueOf(u))
+				return nil
+			}
+
+			return setString(field, val.String)
+		}
+	case "json", "jsonb":
+		var val []byte
+		return &val, func(field reflect.Value) error {
+			if val == nil {
+				field.Set(reflect.Zero(field.Type()))
+				return nil
+			}
+
+			if field.Kind() == reflect.String {
+				field.SetString(string(val))
+				return nil
+			}
+
+			if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8 {
+				field.SetBytes(val)
+				return nil
+			}
+
+			return json.Unmarshal(val, field.Addr().Interface())
+		}
+	case "bytea":
+		var val []byte
+		return &val, func(field reflect.Value) error {
+			if field.Kind() != reflect.Slice || field.Type().Elem().Kind() != reflect.Uint8 {
+				return fieldTypeError(field, "bytea")
+			}
+			field.SetBytes(val)
+			return nil
+		}
+	}
+
+	var val interface{}
+	return &val, func(field reflect.Value) error {
+		if val == nil {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+
+		v := reflect.ValueOf(val)
+		if !v.Type().ConvertibleTo(field.Type()) {
+			return fieldTypeError(field, colCfg.Type)
+		}
+		field.Set(v.Convert(field.Type()))
+		return nil
+	}
+}
+
+// Приёмник Scan для поля сущности: значение передаётся sql.Scanner типа поля, если он реализован, иначе
+// переносится по типу колонки. Для поля-указателя NULL становится nil, а не нулевым значением
+type fieldScanner struct {
+	colCfg    *TableColumnConfig
+	fieldType reflect.Type
+	value     reflect.Value
+}
+
+func (s *fieldScanner) Scan(src interface{}) error {
+	s.value = reflect.New(s.fieldType).Elem()
+
+	target := s.value
+	if s.fieldType.Kind() == reflect.Ptr {
+		if src == nil {
+			return nil
+		}
+
+		elem := reflect.New(s.fieldType.Elem())
+		s.value.Set(elem)
+		target = elem.Elem()
+	}
+
+	if scanner, ok := target.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(src)
+	}
+
+	dest, assign := columnScanner(s.colCfg)
+	if scanner, ok := dest.(sql.Scanner); ok {
+		if err := scanner.Scan(src); err != nil {
+			return err
+		}
+	} else {
+		switch d := dest.(type) {
+		case *[]byte:
+			switch v := src.(type) {
+			case []byte:
+				*d = append([]byte{}, v...)
+			case string:
+				*d = []byte(v)
+			case nil:
+				*d = nil
+			default:
+				return fmt.Errorf("Can not scan %T into []byte", src)
+			}
+		case *interface{}:
+			if b, ok := src.([]byte); ok {
+				src = append([]byte{}, b...)
+			}
+			*d = src
+		}
+	}
+
+	return assign(target)
+}
+
+// Значение поля через driver.Valuer; nil-указатель - NULL
+func valuerValue(value interface{}) (interface{}, bool, error) {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, true, nil
+	}
+
+	valuer, ok := value.(driver.Valuer)
+	if !ok {
+		return nil, false, nil
+	}
+
+	result, err := valuer.Value()
+
+	return result, true, err
+}
+
+// Значение для колонки json/jsonb в виде текста JSON: строки и json.RawMessage считаются готовым JSON,
+// остальные значения сериализуются. nil, nil-карта и nil-срез в nullable-колонке записываются как NULL
+func jsonValueForSQL(value interface{}, nullable bool) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		if nullable {
+			return nil, nil
+		}
+		return "null", nil
+	case string:
+		if v == "" && nullable {
+			return nil, nil
+		}
+		return v, nil
+	case json.RawMessage:
+		if v == nil {
+			return jsonValueForSQL(nil, nullable)
+		}
+		return string(v), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	if (rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice) && rv.IsNil() && nullable {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// Аргумент запроса, сериализуемый в JSON при выполнении запроса; ошибка сериализации возвращается драйвером
+type jsonArg struct {
+	value interface{}
+}
+
+func (a jsonArg) Value() (driver.Value, error) {
+	if raw, ok := a.value.(json.RawMessage); ok {
+		return string(raw), nil
+	}
+
+	data, err := json.Marshal(a.value)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// Значение поля для записи в колонку новых типов: UUID [16]byte передаётся строкой, Decimal - десятичной записью.
+// Нулевые время, Decimal, UUID и nil []byte в nullable-колонке записываются как NULL
+func typedValueForSQL(typeStr string, value interface{}, nullable bool) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		if v.IsZero() && nullable {
+			return nil
+		}
+	case Decimal:
+		if v.IsZero() && nullable {
+			return nil
+		}
+		return v.String()
+	case [16]byte:
+		if v == [16]byte{} && nullable {
+			return nil
+		}
+		return formatUUID(v)
+	case []byte:
+		if v == nil {
+			if nullable {
+				return nil
+			}
+			return []byte{}
+		}
+	case string:
+		if v == "" && nullable && typeStr != "bytea" {
+			return nil
+		}
+	}
+
+	return value
+}
+
+func formatUUID(u [16]byte) string {
+	s := hex.EncodeToString(u[:])
+
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+func parseUUID(s string) ([16]byte, error) {
+	var u [16]byte
+
+	data, err := hex.DecodeString(strings.ReplaceAll(strings.Trim(s, "{}"), "-", ""))
+	if err != nil || len(data) != 16 {
+		return u, errors.New("Invalid uuid value: " + s)
+	}
+
+	copy(u[:], data)
+
+	return u, nil
+}
+
+func setString(field reflect.Value, value string) error {
+	if field.Kind() != reflect.String {
+		return fieldTypeError(field, "string")
+	}
+	field.SetString(value)
+
+	return nil
+}
+
+func setFloat(field reflect.Value, value float64) error {
+	if field.Kind() != reflect.Float32 && field.Kind() != reflect.Float64 {
+		return fieldTypeError(field, "float")
+	}
+	field.SetFloat(value)
+
+	return nil
+}
+
+func setInt(field reflect.Value, value int64) error {
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		field.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		field.SetUint(uint64(value))
+	default:
+		return fieldTypeError(field, "int")
+	}
+
+	return nil
+}
+
+func fieldTypeError(field reflect.Value, typeStr string) error {
+	return fmt.Errorf("Field of type %s can not hold column of type %s", field.Type(), typeStr)
+}
@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTypedValueForSQL(t *testing.T) {
+	price, _ := NewDecimal("1.20")
+	created := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		typeStr  string
+		value    interface{}
+		nullable bool
+		expected interface{}
+	}{
+		{"timestamptz", time.Time{}, true, nil},
+		{"timestamptz", time.Time{}, false, time.Time{}},
+		{"timestamptz", created, true, created},
+		{"numeric", Decimal{}, true, nil},
+		{"numeric", Decimal{}, false, "0"},
+		{"numeric", price, false, "1.20"},
+		{"uuid", [16]byte{}, true, nil},
+		{"uuid", exprRef, false, exprRefString},
+		{"bytea", "", true, ""},
+		{"string", "", true, nil},
+	}
+
+	for _, c := range cases {
+		if value := typedValueForSQL(c.typeStr, c.value, c.nullable); !reflect.DeepEqual(value, c.expected) {
+			t.Errorf("%s %#v (nullable %v) = %#v, expected %#v", c.typeStr, c.value, c.nullable, value, c.expected)
+		}
+	}
+
+	if value := typedValueForSQL("bytea", []byte(nil), false); !reflect.DeepEqual(value, []byte{}) {
+		t.Errorf("nil []byte in NOT NULL column = %#v", value)
+	}
+}
+
+func TestTypedColumnScan(t *testing.T) {
+	price, _ := NewDecimal("10.50")
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		typeStr  string
+		field    interface{}
+		src      interface{}
+		expected interface{}
+	}{
+		{"numeric", Decimal{}, []byte("10.50"), price},
+		{"numeric", Decimal{}, nil, Decimal{}},
+		{"numeric", float64(0), "2.5", 2.5},
+		{"numeric", "", []byte("1.10"), "1.10"},
+		{"uuid", [16]byte{}, exprRefString, exprRef},
+		{"uuid", [16]byte{}, nil, [16]byte{}},
+		{"uuid", "", []byte(exprRefString), exprRefString},
+		{"timestamptz", time.Time{}, created, created},
+		{"date", time.Time{}, nil, time.Time{}},
+		{"bytea", []byte{}, []byte("hi"), []byte("hi")},
+		{"float4", float32(0), float64(1.5), float32(1.5)},
+	}
+
+	for _, c := range cases {
+		value := scanColumn(t, c.typeStr, reflect.TypeOf(c.field), c.src).Interface()
+		if !reflect.DeepEqual(value, c.expected) {
+			t.Errorf("%s %#v into %T = %#v, expected %#v", c.typeStr, c.src, c.field, value, c.expected)
+		}
+	}
+
+	for _, c := range []struct {
+		typeStr string
+		field   interface{}
+		src     interface{}
+	}{
+		{"uuid", [16]byte{}, "not-a-uuid"},
+		{"numeric", Decimal{}, "1.2.3"},
+		{"bytea", "", []byte("hi")},
+		{"timestamptz", "", created},
+	} {
+		scanner := &fieldScanner{colCfg: &TableColumnConfig{Type: c.typeStr, Nullable: true}, fieldType: reflect.TypeOf(c.field)}
+		if err := scanner.Scan(c.src); err == nil {
+			t.Errorf("%s %#v into %T: expected error", c.typeStr, c.src, c.field)
+		}
+	}
+}
+
+// Значение в БД - строка в нижнем регистре, в сущности - в верхнем
+type upperName string
+
+func (n *upperName) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*n = upperName(strings.ToUpper(v))
+	case []byte:
+		*n = upperName(strings.ToUpper(string(v)))
+	default:
+		return errors.New("upperName: unsupported value")
+	}
+
+	return nil
+}
+
+func (n upperName) Value() (driver.Value, error) {
+	return strings.ToLower(string(n)), nil
+}
+
+type scanRow struct {
+	ID    int64      `repo:"pk"`
+	Name  upperName  `repo:"type=string"`
+	Alias *upperName `repo:"type=string"`
+	Note  *string    `repo:""`
+	Age   *int32     `repo:""`
+	Seen  *time.Time `repo:""`
+}
+
+func TestPointerAndScannerFields(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[scanRow](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := []string{"id", "name", "alias", "note", "age", "seen"}
+	seen := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	f.addRows(columns, []driver.Value{int64(1), "ann", nil, nil, nil, nil}, []driver.Value{int64(2), "bob", "b", "", int64(0), seen})
+
+	rows, err := repo.FindByCtx(context.Background(), nil, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rows[0].Name != "ANN" || rows[0].Alias != nil || rows[0].Note != nil || rows[0].Age != nil || rows[0].Seen != nil {
+		t.Errorf("NULL must scan to nil pointers: %+v", rows[0])
+	}
+
+	second := rows[1]
+	if second.Alias == nil || *second.Alias != "B" || second.Note == nil || *second.Note != "" ||
+		second.Age == nil || *second.Age != 0 || second.Seen == nil || !second.Seen.Equal(seen) {
+		t.Errorf("values must scan into pointers: %+v", second)
+	}
+
+	// Пустая строка и ноль по указателю записываются как значения, nil-указатель - как NULL
+	_, args, err := (&QueryBuilder{}).Insert(mustStructConfig(t, scanRow{}), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(args, []interface{}{"bob", "b", "", int32(0), seen}) {
+		t.Errorf("unexpected arguments %#v", args)
+	}
+
+	_, args, err = (&QueryBuilder{}).Insert(mustStructConfig(t, scanRow{}), rows[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(args, []interface{}{"ann", nil, nil, nil, nil}) {
+		t.Errorf("nil pointers must be written as NULL: %#v", args)
+	}
+}
+
+type jsonDoc struct {
+	A int      `json:"a"`
+	B []string `json:"b,omitempty"`
+}
+
+func TestJsonValueForSQL(t *testing.T) {
+	var nilMap map[string]int
+	var nilRaw json.RawMessage
+
+	cases := []struct {
+		value    interface{}
+		nullable bool
+		expected interface{}
+	}{
+		{nil, true, nil},
+		{nil, false, "null"},
+		{"", true, nil},
+		{`{"a":1}`, false, `{"a":1}`},
+		{json.RawMessage(`[1,2]`), false, `[1,2]`},
+		{nilRaw, true, nil},
+		{nilRaw, false, "null"},
+		{nilMap, true, nil},
+		{nilMap, false, "null"},
+		{map[string]int{"a": 1}, true, `{"a":1}`},
+		{jsonDoc{A: 1}, false, `{"a":1}`},
+		{[]string{}, true, `[]`},
+	}
+
+	for _, c := range cases {
+		value, err := jsonValueForSQL(c.value, c.nullable)
+		if err != nil {
+			t.Errorf("%#v: %v", c.value, err)
+			continue
+		}
+		if value != c.expected {
+			t.Errorf("%#v (nullable %v) = %#v, expected %#v", c.value, c.nullable, value, c.expected)
+		}
+	}
+
+	if _, err := jsonValueForSQL(map[string]interface{}{"f": func() {}}, false); err == nil {
+		t.Errorf("expected error for value that can not be serialized")
+	}
+}
+
+type jsonRow struct {
+	ID   int64           `repo:"pk"`
+	Doc  jsonDoc         `repo:"type=jsonb"`
+	Opt  *jsonDoc        `repo:"type=jsonb"`
+	Tags map[string]int  `repo:"type=jsonb,nullable"`
+	Raw  json.RawMessage `repo:"type=json"`
+	Text string          `repo:"type=json"`
+}
+
+func TestJsonColumns(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[jsonRow](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.addRows([]string{"id", "doc", "opt", "tags", "raw", "text"},
+		[]driver.Value{int64(1), []byte(`{"a":2,"b":["y"]}`), nil, []byte(`{"x":1,"y":2}`), []byte(`[1]`), `{"t":true}`},
+		[]driver.Value{int64(2), `{"a":3}`, `{"a":4}`, nil, nil, nil})
+
+	rows, err := repo.FindBy(nil, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := rows[0]
+	if !reflect.DeepEqual(first.Doc, jsonDoc{A: 2, B: []string{"y"}}) || first.Opt != nil ||
+		!reflect.DeepEqual(first.Tags, map[string]int{"x": 1, "y": 2}) || string(first.Raw) != `[1]` || first.Text != `{"t":true}` {
+		t.Errorf("unexpected JSON values %+v", first)
+	}
+
+	second := rows[1]
+	if second.Doc.A != 3 || second.Opt == nil || second.Opt.A != 4 || second.Tags != nil || second.Raw != nil {
+		t.Errorf("unexpected JSON values %+v", second)
+	}
+
+	sql, args, err := (&QueryBuilder{}).Insert(mustStructConfig(t, jsonRow{}), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []interface{}{`{"a":2,"b":["y"]}`, nil, `{"x":1,"y":2}`, `[1]`, `{"t":true}`}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("unexpected JSON arguments of %s: %#v", sql, args)
+	}
+
+	scanner := &fieldScanner{colCfg: &TableColumnConfig{Type: "jsonb"}, fieldType: reflect.TypeOf(jsonDoc{})}
+	if err := scanner.Scan([]byte(`{"a":"x"}`)); err == nil {
+		t.Errorf("expected error for JSON of another shape")
+	}
+}
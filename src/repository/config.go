@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
 	"github.com/spf13/viper"
 )
@@ -16,6 +17,8 @@ type TableRelationConfig struct {
 	Type   string
 	Target string
 	Params map[string]interface{}
+	// Поле сущности; по умолчанию - имя связи в CamelCase
+	FieldName string
 }
 
 func NewTableRelationConfig(typeStr string, target string) *TableRelationConfig {
@@ -34,7 +37,16 @@ type TableConfig struct {
 	TableColumns    map[string]*TableColumnConfig
 	TableColumnsArr []string
 	Relations       map[string]*TableRelationConfig
-	Dir             string
+	RelationsArr    []string
+	SoftDelete      string
+	Version         string
+	// Имена ограничений БД и поля, которым они соответствуют в ответах API (секция constraints)
+	Constraints map[string]string
+	Dir         string
+	// Конфигурация построена по тегам структуры: конфигурации целей связей строятся по типам их полей
+	fromStruct bool
+	// Источник конфигураций целей связей
+	registry *ConfigRegistry
 }
 
 func NewTableConfig(tableName string, PK string, dir string) *TableConfig {
@@ -45,6 +57,8 @@ func NewTableConfig(tableName string, PK string, dir string) *TableConfig {
 		TableColumns:    make(map[string]*TableColumnConfig),
 		TableColumnsArr: []string{},
 		Relations:       make(map[string]*TableRelationConfig),
+		RelationsArr:    []string{},
+		Constraints:     make(map[string]string),
 		Dir:             dir,
 	}
 }
@@ -53,58 +67,138 @@ func (cfg *TableConfig) dump() {
 	// TODO: implement
 }
 
-func CreateTableConfig(dir string, tableName string) *TableConfig {
-	viper.SetConfigName(tableName)
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(dir)
+// Конфигурация таблицы из yaml-файла dir/tableName.yaml. Ошибки чтения и разбора возвращаются как *ConfigError.
+// Файл читается один раз, конфигурация берётся из кэша ConfigRegistry каталога dir, она общая и не должна изменяться
+func CreateTableConfig(dir string, tableName string) (*TableConfig, error) {
+	return dirRegistry(dir).Get(tableName)
+}
+
+// Разбор yaml-конфигурации из отдельного экземпляра viper.
+// При partial table_name, pk и columns необязательны: файл дополняет конфигурацию из тегов структуры
+func parseTableConfig(v *viper.Viper, dir string, tableName string, partial bool) (*TableConfig, error) {
+	configError := func(err error) (*TableConfig, error) {
+		return nil, &ConfigError{Table: tableName, Dir: dir, Err: err}
+	}
+
+	tbl, ok := v.Get("table_name").(string)
+	if !ok && !(partial && v.Get("table_name") == nil) {
+		return configError(errors.New("table_name must be a string"))
+	}
+
+	pk, ok := v.Get("pk").(string)
+	if !ok && !(partial && v.Get("pk") == nil) {
+		return configError(errors.New("pk must be a string"))
+	}
 
-	err := viper.ReadInConfig()
-	if err != nil { // Handle errors reading the config file
-		panic(fmt.Errorf("Fatal error config file: %w \n", err))
+	columns, ok := v.Get("columns").([]interface{})
+	if !ok && !(partial && v.Get("columns") == nil) {
+		return configError(errors.New("columns must be a list"))
 	}
 
-	tbl := viper.Get("table_name")
+	relations := v.Get("relations")
+	constraints := v.Get("constraints")
+	softDelete := v.GetString("soft_delete")
+	version := v.GetString("version")
+
+	newConfig := NewTableConfig(tbl, pk, dir)
+	newConfig.SoftDelete = softDelete
+	newConfig.Version = version
+
+	// Секция constraints - список "имя ограничения: поле", сохраняющий регистр имён, или карта
+	switch c := constraints.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range c {
+			itemMap, ok := item.(map[interface{}]interface{})
+			if !ok {
+				return configError(fmt.Errorf("invalid constraint definition: %v", item))
+			}
 
-	pk := viper.Get("pk")
-	columns := viper.Get("columns")
-	relations := viper.Get("relations")
+			for constraintName, field := range itemMap {
+				newConfig.Constraints[fmt.Sprint(constraintName)] = fmt.Sprint(field)
+			}
+		}
+	default:
+		for constraintName, field := range v.GetStringMapString("constraints") {
+			newConfig.Constraints[constraintName] = field
+		}
+	}
 
-	//fmt.Println(columns)
+	for _, colConfig := range columns {
+		colMap, ok := colConfig.(map[interface{}]interface{})
+		if !ok {
+			return configError(fmt.Errorf("invalid column definition: %v", colConfig))
+		}
 
-	newConfig := NewTableConfig(tbl.(string), pk.(string), dir)
+		for colName, cf := range colMap {
+			configData, ok := cf.(map[interface{}]interface{})
+			if !ok {
+				return configError(fmt.Errorf("invalid definition of column %v", colName))
+			}
 
-	//columnConfigs := []TableColumnConfig{}
+			nullable, ok := configData["nullable"].(bool)
+			if !ok {
+				return configError(fmt.Errorf("nullable of column %v must be a bool", colName))
+			}
 
-	for _, colConfig := range columns.([]interface{}) {
+			typeStr, ok := configData["type"].(string)
+			if !ok {
+				return configError(fmt.Errorf("type of column %v must be a string", colName))
+			}
 
-		for colName, cf := range colConfig.(map[interface{}]interface{}) {
-			configData := cf.(map[interface{}]interface{})
-			c := NewTableColumnConfig(configData["nullable"].(bool), configData["type"].(string))
+			c := NewTableColumnConfig(nullable, typeStr)
 
 			if zn, ok := configData["zeroToNull"]; ok {
-				if zn.(bool) {
+				if zn == true {
 					c.ZeroToNull = true
 				}
 			}
 
 			if val, ok := configData["fieldName"]; ok {
-				c.FieldName = val.(string)
+				c.FieldName = fmt.Sprint(val)
 			}
 
-			newConfig.TableColumns[colName.(string)] = c
-			newConfig.TableColumnsArr = append(newConfig.TableColumnsArr, colName.(string))
+			newConfig.TableColumns[fmt.Sprint(colName)] = c
+			newConfig.TableColumnsArr = append(newConfig.TableColumnsArr, fmt.Sprint(colName))
 		}
 	}
 
 	if relations != nil {
-		for _, relConfig := range relations.([]interface{}) {
+		relationsList, ok := relations.([]interface{})
+		if !ok {
+			return configError(errors.New("relations must be a list"))
+		}
+
+		for _, relConfig := range relationsList {
+			relMap, ok := relConfig.(map[interface{}]interface{})
+			if !ok {
+				return configError(fmt.Errorf("invalid relation definition: %v", relConfig))
+			}
+
+			for relName, cf := range relMap {
+				configData, ok := cf.(map[interface{}]interface{})
+				if !ok {
+					return configError(fmt.Errorf("invalid definition of relation %v", relName))
+				}
 
-			for relName, cf := range relConfig.(map[interface{}]interface{}) {
-				configData := cf.(map[interface{}]interface{})
-				c := NewTableRelationConfig(configData["type"].(string), configData["target"].(string))
+				typeStr, ok := configData["type"].(string)
+				if !ok {
+					return configError(fmt.Errorf("type of relation %v must be a string", relName))
+				}
+
+				target, ok := configData["target"].(string)
+				if !ok {
+					return configError(fmt.Errorf("target of relation %v must be a string", relName))
+				}
+
+				c := NewTableRelationConfig(typeStr, target)
 
 				if val, ok := configData["foreign_key"]; ok {
-					c.Params["foreign_key"] = val.(string)
+					c.Params["foreign_key"] = fmt.Sprint(val)
+				}
+
+				if val, ok := configData["fieldName"]; ok {
+					c.FieldName = fmt.Sprint(val)
 				}
 
 				if val, ok := configData["cascade_persist"]; ok {
@@ -115,12 +209,11 @@ func CreateTableConfig(dir string, tableName string) *TableConfig {
 						c.Params["cascade_persist"] = false
 					}
 				}
-				newConfig.Relations[relName.(string)] = c
+				newConfig.Relations[fmt.Sprint(relName)] = c
+				newConfig.RelationsArr = append(newConfig.RelationsArr, fmt.Sprint(relName))
 			}
 		}
 	}
 
-	//fmt.Println(columnConfigs)
-
-	return newConfig
+	return newConfig, nil
 }
@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"github.com/spf13/viper"
+	"io/fs"
+	"os"
+	"reflect"
+	"sync"
+)
+
+// Загрузчик и кэш конфигураций таблиц из yaml-файлов <имя таблицы>.yaml (или .yml) каталога или fs.FS, в том числе embed.FS.
+// Каждый файл читается отдельным экземпляром viper, разобранные конфигурации кэшируются, методы безопасны
+// для одновременного вызова из нескольких горутин. Возвращаемые конфигурации общие и не должны изменяться
+type ConfigRegistry struct {
+	fsys          fs.FS
+	dir           string
+	mu            sync.RWMutex
+	configs       map[string]*TableConfig
+	structConfigs map[structConfigKey]*TableConfig
+}
+
+// Конфигурация типа может быть запрошена и для другой таблицы, например для цели связи с target=
+type structConfigKey struct {
+	t         reflect.Type
+	tableName string
+}
+
+func NewConfigRegistry(dir string) *ConfigRegistry {
+	if dir == "" {
+		dir = "."
+	}
+
+	registry := NewConfigRegistryFS(os.DirFS(dir))
+	registry.dir = dir
+
+	return registry
+}
+
+func NewConfigRegistryFS(fsys fs.FS) *ConfigRegistry {
+	return &ConfigRegistry{
+		fsys:          fsys,
+		configs:       make(map[string]*TableConfig),
+		structConfigs: make(map[structConfigKey]*TableConfig),
+	}
+}
+
+// Реестры каталогов для CreateTableConfig и конфигураций, созданных без реестра
+var dirRegistries = struct {
+	sync.Mutex
+	registries map[string]*ConfigRegistry
+}{registries: make(map[string]*ConfigRegistry)}
+
+func dirRegistry(dir string) *ConfigRegistry {
+	dirRegistries.Lock()
+	defer dirRegistries.Unlock()
+
+	registry, ok := dirRegistries.registries[dir]
+	if !ok {
+		registry = NewConfigRegistry(dir)
+		dirRegistries.registries[dir] = registry
+	}
+
+	return registry
+}
+
+// Конфигурация таблицы из файла tableName.yaml
+func (r *ConfigRegistry) Get(tableName string) (*TableConfig, error) {
+	r.mu.RLock()
+	cfg, ok := r.configs[tableName]
+	r.mu.RUnlock()
+
+	if ok {
+		return cfg, nil
+	}
+
+	cfg, err := r.read(tableName, false)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cached, ok := r.configs[tableName]; ok {
+		return cached, nil
+	}
+	r.configs[tableName] = cfg
+
+	return cfg, nil
+}
+
+// Конфигурация по тегам repo типа t, дополненная yaml-файлом таблицы, если он есть (см. CreateTableConfigForStruct)
+func (r *ConfigRegistry) ForStruct(t reflect.Type) (*TableConfig, error) {
+	return r.forStructTable(t, "")
+}
+
+// Конфигурация по тегам типа t для таблицы tableName; при пустом tableName - для таблицы типа
+func (r *ConfigRegistry) forStructTable(t reflect.Type, tableName string) (*TableConfig, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if tableName == "" && t.Kind() == reflect.Struct {
+		tableName = tableNameOfType(t)
+	}
+
+	key := structConfigKey{t: t, tableName: tableName}
+
+	r.mu.RLock()
+	cfg, ok := r.structConfigs[key]
+	r.mu.RUnlock()
+
+	if ok {
+		return cfg, nil
+	}
+
+	cfg, err := r.structConfig(t, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cached, ok := r.structConfigs[key]; ok {
+		return cached, nil
+	}
+	r.structConfigs[key] = cfg
+
+	return cfg, nil
+}
+
+func (r *ConfigRegistry) structConfig(t reflect.Type, tableName string) (*TableConfig, error) {
+	cfg, err := structTableConfig(t)
+	if err != nil {
+		return nil, err
+	}
+
+	if tableName != "" {
+		cfg.TableName = tableName
+	}
+	cfg.Dir = r.dir
+	cfg.registry = r
+
+	override, err := r.read(cfg.TableName, true)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if err == nil {
+		cfg = mergeTableConfig(cfg, override)
+	}
+
+	if err := validateTableConfig(cfg, t); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Чтение и разбор файла без кэширования
+func (r *ConfigRegistry) read(tableName string, partial bool) (*TableConfig, error) {
+	data, err := fs.ReadFile(r.fsys, tableName+".yaml")
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = fs.ReadFile(r.fsys, tableName+".yml")
+	}
+
+	if err != nil {
+		return nil, &ConfigError{Table: tableName, Dir: r.dir, Err: err}
+	}
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, &ConfigError{Table: tableName, Dir: r.dir, Err: err}
+	}
+
+	cfg, err := parseTableConfig(v, r.dir, tableName, partial)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.registry = r
+
+	return cfg, nil
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+const groupsYaml = `
+table_name: groups
+pk: id
+columns:
+  - id: {nullable: false, type: int8}
+  - title: {nullable: false, type: string}
+`
+
+func TestCreateTableConfigCached(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "groups.yml")
+	if err := os.WriteFile(path, []byte(groupsYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := CreateTableConfig(dir, "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.TableName != "groups" || cfg.PK != "id" || !reflect.DeepEqual(cfg.TableColumnsArr, []string{"id", "title"}) {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := CreateTableConfig(dir, "groups")
+	if err != nil || cached != cfg {
+		t.Errorf("expected cached config, got %p, %v", cached, err)
+	}
+
+	_, err = CreateTableConfig(dir, "missing")
+	var configError *ConfigError
+	if !errors.As(err, &configError) || !errors.Is(err, fs.ErrNotExist) || configError.Table != "missing" {
+		t.Errorf("expected ConfigError wrapping fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestCreateTableConfigForStructCached(t *testing.T) {
+	first, err := CreateTableConfigForStruct("", reflect.TypeOf(iterGroup{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := TableConfigFromStruct(reflect.TypeOf(&iterGroup{}))
+	if err != nil || second != first {
+		t.Errorf("expected cached struct config, got %p, %v", second, err)
+	}
+}
+
+func TestConfigRegistryConcurrentGet(t *testing.T) {
+	registry := NewConfigRegistryFS(fstest.MapFS{"groups.yaml": &fstest.MapFile{Data: []byte(groupsYaml)}})
+
+	configs := make([]*TableConfig, 20)
+	var wg sync.WaitGroup
+	for i := range configs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			configs[i], _ = registry.Get("groups")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, cfg := range configs {
+		if cfg == nil || cfg != configs[0] {
+			t.Fatalf("expected one shared config, got %p and %p", cfg, configs[0])
+		}
+	}
+}
+
+func TestConfigRegistryInvalidYaml(t *testing.T) {
+	registry := NewConfigRegistryFS(fstest.MapFS{
+		"bad.yaml":  &fstest.MapFile{Data: []byte("table_name: bad\npk: id\ncolumns: 5\n")},
+		"bad2.yaml": &fstest.MapFile{Data: []byte("table_name: bad2\npk: id\ncolumns:\n  - id: {nullable: 1, type: int8}\n")},
+	})
+
+	for _, table := range []string{"bad", "bad2"} {
+		var configError *ConfigError
+		if _, err := registry.Get(table); !errors.As(err, &configError) {
+			t.Errorf("%s: expected ConfigError, got %v", table, err)
+		}
+	}
+}
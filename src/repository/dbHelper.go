@@ -2,6 +2,7 @@ package repository
 
 import (
 	"bitbucket.org/pkg/inflect"
+	"errors"
 	"reflect"
 )
 
@@ -49,8 +50,13 @@ func GetTableRelationMap(cfg *TableConfig, t reflect.Type) (map[string]string, [
 
 	result := make(map[string]string)
 	notFound := []string{}
-	for relName, _ := range cfg.Relations {
-		typeStruct, relFound := t.FieldByName(inflect.Camelize(relName))
+	for relName, relCfg := range cfg.Relations {
+		fieldName := relCfg.FieldName
+		if fieldName == "" {
+			fieldName = inflect.Camelize(relName)
+		}
+
+		typeStruct, relFound := t.FieldByName(fieldName)
 
 		if relFound {
 			result[relName] = typeStruct.Name
@@ -61,3 +67,103 @@ func GetTableRelationMap(cfg *TableConfig, t reflect.Type) (map[string]string, [
 
 	return result, notFound
 }
+
+// Связи one_to_one/many_to_one, внешние ключи которых выбираются вместе с колонками записи
+func GetTableRelationKeys(cfg *TableConfig, t reflect.Type) []string {
+	relations, _ := GetTableRelationMap(cfg, t)
+
+	result := []string{}
+	for _, relName := range cfg.RelationsArr {
+		relCfg := cfg.Relations[relName]
+		if relCfg.Type != "one_to_one" && relCfg.Type != "many_to_one" {
+			continue
+		}
+
+		if _, ok := relations[relName]; !ok {
+			continue
+		}
+
+		if _, ok := relCfg.Params["foreign_key"]; ok {
+			result = append(result, relName)
+		}
+	}
+
+	return result
+}
+
+// ID сущности из поля ID или Id
+func entityId(v reflect.Value) (int64, bool) {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+
+	for _, name := range []string{"ID", "Id"} {
+		if id, ok := intFieldValue(v, name); ok {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+// Значение целочисленного поля name структуры v
+func intFieldValue(v reflect.Value, name string) (int64, bool) {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+
+	field := v.FieldByName(name)
+	if !field.IsValid() {
+		return 0, false
+	}
+
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return field.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(field.Uint()), true
+	}
+
+	return 0, false
+}
+
+// SQLSTATE ошибки драйвера Postgres: через метод SQLState() (pgx, lib/pq) или строковое поле Code
+func sqlState(err error) string {
+	var stater interface{ SQLState() string }
+	if errors.As(err, &stater) {
+		return stater.SQLState()
+	}
+
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		v := reflect.Indirect(reflect.ValueOf(e))
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+
+		if code := v.FieldByName("Code"); code.IsValid() && code.Kind() == reflect.String && code.Len() == 5 {
+			return code.String()
+		}
+	}
+
+	return ""
+}
+
+// Строковое поле ошибки драйвера Postgres: первое найденное из names (Constraint в lib/pq, ConstraintName в pgx)
+func driverErrorField(err error, names ...string) string {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		v := reflect.Indirect(reflect.ValueOf(e))
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+
+		for _, name := range names {
+			if field := v.FieldByName(name); field.IsValid() && field.Kind() == reflect.String {
+				return field.String()
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+var decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// Значение колонки numeric без потери точности: хранится в десятичной записи.
+// Нулевое значение Decimal{} равно 0 и, как пустая строка, записывается в nullable-колонку как NULL
+type Decimal struct {
+	value string
+}
+
+func NewDecimal(value string) (Decimal, error) {
+	if value != "NaN" && !decimalPattern.MatchString(value) {
+		return Decimal{}, errors.New("Invalid decimal value: " + value)
+	}
+
+	return Decimal{value: value}, nil
+}
+
+func DecimalFromFloat(value float64) Decimal {
+	return Decimal{value: strconv.FormatFloat(value, 'f', -1, 64)}
+}
+
+func (d Decimal) String() string {
+	if d.value == "" {
+		return "0"
+	}
+
+	return d.value
+}
+
+func (d Decimal) IsZero() bool {
+	return d.value == ""
+}
+
+func (d Decimal) Float64() (float64, error) {
+	return strconv.ParseFloat(d.String(), 64)
+}
+
+// Точное значение; для NaN возвращается false
+func (d Decimal) Rat() (*big.Rat, bool) {
+	return new(big.Rat).SetString(d.String())
+}
+
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+func (d *Decimal) Scan(src interface{}) error {
+	var str string
+	switch v := src.(type) {
+	case nil:
+		*d = Decimal{}
+		return nil
+	case string:
+		str = v
+	case []byte:
+		str = string(v)
+	case float64:
+		*d = DecimalFromFloat(v)
+		return nil
+	case int64:
+		str = strconv.FormatInt(v, 10)
+	default:
+		return fmt.Errorf("Can not scan %T into Decimal", src)
+	}
+
+	value, err := NewDecimal(str)
+	if err != nil {
+		return err
+	}
+
+	*d = value
+
+	return nil
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		var number json.Number
+		if err := json.Unmarshal(data, &number); err != nil {
+			return err
+		}
+		str = number.String()
+	}
+
+	value, err := NewDecimal(str)
+	if err != nil {
+		return err
+	}
+
+	*d = value
+
+	return nil
+}
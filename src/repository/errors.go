@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Запись не найдена (Find, FindOneBy)
+var ErrNotFound = errors.New("Entity not found")
+
+// Колонке или связи из конфигурации не соответствует поле сущности
+var ErrFieldNotMapped = errors.New("Field not mapped")
+
+// Некорректное значение в фильтрах или сортировке
+var ErrInvalidFilter = errors.New("Invalid filter")
+
+// Запись была изменена или удалена после загрузки (не совпала версия при Update)
+var ErrStaleEntity = errors.New("Stale entity")
+
+var ErrInvalidCursor = errors.New("Invalid pagination cursor")
+
+var ErrSessionClosed = errors.New("Session is closed")
+
+// В UPDATE нет колонок: у сущности нет колонок кроме первичного ключа или пуст список onlyColumns без колонки версии
+var ErrNothingToUpdate = errors.New("Nothing to update")
+
+// Ошибка чтения или некорректное содержимое конфигурации таблицы
+type ConfigError struct {
+	Table string
+	Dir   string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Dir == "" {
+		return fmt.Sprintf("Config error for table %s: %v", e.Table, e.Err)
+	}
+
+	return fmt.Sprintf("Config error for table %s in %s: %v", e.Table, e.Dir, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// Виды нарушений ограничений; ConstraintViolation совпадает с ними по errors.Is
+var ErrUniqueViolation = errors.New("Unique violation")
+var ErrForeignKeyViolation = errors.New("Foreign key violation")
+var ErrNotNullViolation = errors.New("Not null violation")
+var ErrCheckViolation = errors.New("Check violation")
+var ErrExclusionViolation = errors.New("Exclusion violation")
+
+// SQLSTATE класса 23 и соответствующие виды нарушений
+var constraintKinds = map[string]error{
+	"23505": ErrUniqueViolation,
+	"23503": ErrForeignKeyViolation,
+	"23502": ErrNotNullViolation,
+	"23514": ErrCheckViolation,
+	"23P01": ErrExclusionViolation,
+}
+
+// Нарушение ограничения БД (SQLSTATE класса 23). Table, Column и Constraint - имена из ошибки Postgres,
+// если драйвер их сообщает; Field - имя поля для ответа API из секции constraints конфигурации таблицы,
+// а без сопоставления - колонка ограничения
+type ConstraintViolation struct {
+	Kind       error
+	SQLState   string
+	Table      string
+	Column     string
+	Constraint string
+	Field      string
+	Err        error
+}
+
+func (e *ConstraintViolation) Error() string {
+	kind := "Constraint violation"
+	if e.Kind != nil {
+		kind = e.Kind.Error()
+	}
+
+	name := e.Constraint
+	if name == "" {
+		name = e.Column
+	}
+
+	if name == "" {
+		return fmt.Sprintf("%s (%s) on table %s: %v", kind, e.SQLState, e.Table, e.Err)
+	}
+
+	return fmt.Sprintf("%s %s (%s) on table %s: %v", kind, name, e.SQLState, e.Table, e.Err)
+}
+
+func (e *ConstraintViolation) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+func (e *ConstraintViolation) Unwrap() error {
+	return e.Err
+}
+
+// Ошибка записи в таблицу cfg; нарушения ограничений оборачиваются в ConstraintViolation
+func writeError(cfg *TableConfig, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	state := sqlState(err)
+	if len(state) != 5 || state[:2] != "23" {
+		return err
+	}
+
+	violation := &ConstraintViolation{
+		Kind:       constraintKinds[state],
+		SQLState:   state,
+		Table:      driverErrorField(err, "Table", "TableName"),
+		Column:     driverErrorField(err, "Column", "ColumnName"),
+		Constraint: driverErrorField(err, "Constraint", "ConstraintName"),
+		Err:        err,
+	}
+
+	if violation.Table == "" {
+		violation.Table = cfg.TableName
+	}
+
+	if field, ok := constraintField(cfg, violation.Constraint); ok {
+		violation.Field = field
+	} else if violation.Table == cfg.TableName {
+		violation.Field = violation.Column
+	}
+
+	return violation
+}
+
+// Поле для ограничения из секции constraints. Имена в виде карты viper приводит к нижнему регистру,
+// поэтому при отсутствии точного совпадения имя сравнивается без учёта регистра
+func constraintField(cfg *TableConfig, constraint string) (string, bool) {
+	if constraint == "" {
+		return "", false
+	}
+
+	if field, ok := cfg.Constraints[constraint]; ok {
+		return field, true
+	}
+
+	for name, field := range cfg.Constraints {
+		if strings.EqualFold(name, constraint) {
+			return field, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"testing/fstest"
+)
+
+func TestConstraintFieldMapping(t *testing.T) {
+	registry := NewConfigRegistryFS(fstest.MapFS{
+		"users.yaml": &fstest.MapFile{Data: []byte(`
+table_name: users
+pk: id
+columns:
+  - id: {nullable: false, type: int8}
+  - email: {nullable: false, type: string}
+constraints:
+  - Users_Email_Key: Email
+`)},
+		"accounts.yaml": &fstest.MapFile{Data: []byte(`
+table_name: accounts
+pk: id
+columns:
+  - id: {nullable: false, type: int8}
+constraints:
+  Accounts_Login_Key: login
+`)},
+	})
+
+	users, err := registry.Get("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := registry.Get("accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		cfg        *TableConfig
+		constraint string
+		field      string
+	}{
+		{users, "Users_Email_Key", "Email"},
+		{accounts, "Accounts_Login_Key", "login"},
+		{accounts, "accounts_login_key", "login"},
+	}
+
+	for _, c := range cases {
+		err := writeError(c.cfg, fmt.Errorf("insert: %w", &fakePqError{Code: "23505", Constraint: c.constraint}))
+
+		var violation *ConstraintViolation
+		if !errors.As(err, &violation) || !errors.Is(err, ErrUniqueViolation) {
+			t.Fatalf("expected unique violation, got %v", err)
+		}
+
+		if violation.Field != c.field || violation.Table != c.cfg.TableName {
+			t.Errorf("%s: field %q, table %q", c.constraint, violation.Field, violation.Table)
+		}
+	}
+
+	if err := writeError(users, &fakePqError{Code: "23503"}); !errors.Is(err, ErrForeignKeyViolation) {
+		t.Errorf("expected foreign key violation, got %v", err)
+	}
+
+	plain := errors.New("connection refused")
+	if err := writeError(users, plain); err != plain {
+		t.Errorf("errors without SQLSTATE class 23 must be returned as is, got %v", err)
+	}
+}
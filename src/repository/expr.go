@@ -1,25 +1,146 @@
 package repository
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
 
+// Выражение фильтра по одной колонке. column - уже экранированное имя колонки,
+// arg добавляет значение в параметры запроса и возвращает его плейсхолдер
+type ColumnExpression interface {
+	ColumnSQL(column string, arg func(value interface{}) string) string
+}
+
+// Операнд выражения, который arg приводит к типу колонки фильтра, как значение поля сущности
+// (без замены пустых значений на NULL); element - к типу элемента колонки-массива
+type columnOperand struct {
+	value   interface{}
+	element bool
+}
+
 type IN struct {
 	Ids      []int64
+	Values   []interface{}
 	OrIsNull bool
 }
 
+type NotIN struct {
+	Ids    []int64
+	Values []interface{}
+}
+
 type IsNull struct{}
 
+type IsNotNull struct{}
+
+// Сравнения: >, >=, <, <=, <>
+type GT struct{ Value interface{} }
+type GTE struct{ Value interface{} }
+type LT struct{ Value interface{} }
+type LTE struct{ Value interface{} }
+type NEQ struct{ Value interface{} }
+
+type LIKE struct{ Pattern string }
+type ILIKE struct{ Pattern string }
+
+// Фильтры по колонкам json/jsonb:
+// JSONPathEQ - значение по пути ключей как текст (->>) равно Value, nil - по пути нет значения;
+// JSONContains - колонка содержит Value (@>), Value сериализуется в JSON, json.RawMessage передаётся как есть;
+// JSONHasKey - у объекта верхнего уровня есть ключ (?).
+// Операторы @> и ? есть только у jsonb, колонка json для них приводится к jsonb
+type JSONPathEQ struct {
+	Path  []string
+	Value interface{}
+}
+type JSONContains struct{ Value interface{} }
+type JSONHasKey struct{ Key string }
+
+// Фильтры по колонкам-массивам: ANY - массив содержит значение (значение = ANY(колонка)),
+// ArrayContains - массив содержит все элементы Values (@>), ArrayOverlaps - есть общие с Values элементы (&&).
+// Values - срез элементов
+type ANY struct{ Value interface{} }
+type ArrayContains struct{ Values interface{} }
+type ArrayOverlaps struct{ Values interface{} }
+
+// Карта фильтров в формате SelectBy
+type Filters = map[string]interface{}
+
+// Ключ карты фильтров для логического выражения; логические выражения под другими ключами - ошибка
+const FILTER_EXPRESSION_KEY = "$expr"
+
+// Логические выражения над картами фильтров и другими логическими выражениями.
+// В карте фильтров SelectBy задаются ключом FILTER_EXPRESSION_KEY, например
+// filters := Where(Or(Filters{"Status": "a"}, Filters{"OwnerId": IsNull{}}))
+type OrExpr struct{ Items []interface{} }
+type AndExpr struct{ Items []interface{} }
+type NotExpr struct{ Item interface{} }
+
+// Карта фильтров с логическим выражением; несколько выражений объединяются через And.
+// В карту можно добавить и фильтры колонок
+func Where(items ...interface{}) Filters {
+	if len(items) == 1 {
+		return Filters{FILTER_EXPRESSION_KEY: items[0]}
+	}
+
+	return Filters{FILTER_EXPRESSION_KEY: And(items...)}
+}
+
+func Or(items ...interface{}) *OrExpr {
+	return &OrExpr{Items: items}
+}
+
+func And(items ...interface{}) *AndExpr {
+	return &AndExpr{Items: items}
+}
+
+func Not(item interface{}) *NotExpr {
+	return &NotExpr{Item: item}
+}
+
+func isJSONBExpression(value interface{}) bool {
+	switch value.(type) {
+	case JSONContains, *JSONContains, JSONHasKey, *JSONHasKey:
+		return true
+	}
+
+	return false
+}
+
+func isLogicalExpression(value interface{}) bool {
+	switch value.(type) {
+	case *OrExpr, *AndExpr, *NotExpr:
+		return true
+	}
+
+	return false
+}
+
+func In(values ...interface{}) *IN {
+	return &IN{Values: values}
+}
+
+func NotIn(values ...interface{}) *NotIN {
+	return &NotIN{Values: values}
+}
+
 func (f *IN) AddValue(value interface{}) {
-	f.Ids = append(f.Ids, value.(int64))
+	if id, ok := value.(int64); ok {
+		f.Ids = append(f.Ids, id)
+		return
+	}
+
+	f.Values = append(f.Values, value)
 }
 
 func (f *IN) GetOrIsNull() bool {
 	return f.OrIsNull
 }
 
+func (f *IN) GetValues() []interface{} {
+	return listValues(f.Ids, f.Values)
+}
+
 func (f *IN) ToString() string {
 	strs := []string{}
 	for _, i := range f.Ids {
@@ -28,3 +149,136 @@ func (f *IN) ToString() string {
 
 	return strings.Join(strs, ", ")
 }
+
+func (f *IN) ColumnSQL(column string, arg func(value interface{}) string) string {
+	values := f.GetValues()
+
+	if len(values) == 0 {
+		if f.OrIsNull {
+			return column + " IS NULL"
+		}
+		return "FALSE"
+	}
+
+	str := listPlaceholders(values, arg)
+	if f.OrIsNull {
+		return "(" + column + " IN (" + str + ") OR " + column + " IS NULL)"
+	}
+
+	return column + " IN (" + str + ")"
+}
+
+func (f *NotIN) AddValue(value interface{}) {
+	if id, ok := value.(int64); ok {
+		f.Ids = append(f.Ids, id)
+		return
+	}
+
+	f.Values = append(f.Values, value)
+}
+
+func (f *NotIN) GetValues() []interface{} {
+	return listValues(f.Ids, f.Values)
+}
+
+func (f *NotIN) ColumnSQL(column string, arg func(value interface{}) string) string {
+	values := f.GetValues()
+
+	if len(values) == 0 {
+		return "TRUE"
+	}
+
+	return column + " NOT IN (" + listPlaceholders(values, arg) + ")"
+}
+
+func (f IsNull) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " IS NULL"
+}
+
+func (f IsNotNull) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " IS NOT NULL"
+}
+
+func (f GT) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " > " + arg(columnOperand{value: f.Value})
+}
+
+func (f GTE) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " >= " + arg(columnOperand{value: f.Value})
+}
+
+func (f LT) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " < " + arg(columnOperand{value: f.Value})
+}
+
+func (f LTE) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " <= " + arg(columnOperand{value: f.Value})
+}
+
+func (f NEQ) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " <> " + arg(columnOperand{value: f.Value})
+}
+
+func (f LIKE) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " LIKE " + arg(f.Pattern)
+}
+
+func (f ILIKE) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " ILIKE " + arg(f.Pattern)
+}
+
+func (f JSONPathEQ) ColumnSQL(column string, arg func(value interface{}) string) string {
+	if len(f.Path) == 0 {
+		return "FALSE"
+	}
+
+	path := column
+	for _, key := range f.Path[:len(f.Path)-1] {
+		path += " -> " + arg(key) + "::text"
+	}
+	path = "(" + path + " ->> " + arg(f.Path[len(f.Path)-1]) + "::text)"
+
+	if f.Value == nil {
+		return path + " IS NULL"
+	}
+
+	return path + " = " + arg(fmt.Sprint(f.Value))
+}
+
+func (f JSONContains) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " @> " + arg(jsonArg{f.Value}) + "::jsonb"
+}
+
+func (f JSONHasKey) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " ? " + arg(f.Key)
+}
+
+func (f ANY) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return arg(columnOperand{value: f.Value, element: true}) + " = ANY(" + column + ")"
+}
+
+func (f ArrayContains) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " @> " + arg(arrayArg{f.Values})
+}
+
+func (f ArrayOverlaps) ColumnSQL(column string, arg func(value interface{}) string) string {
+	return column + " && " + arg(arrayArg{f.Values})
+}
+
+func listValues(ids []int64, values []interface{}) []interface{} {
+	result := []interface{}{}
+	for _, i := range ids {
+		result = append(result, i)
+	}
+
+	return append(result, values...)
+}
+
+func listPlaceholders(values []interface{}, arg func(value interface{}) string) string {
+	placeholders := []string{}
+	for _, value := range values {
+		placeholders = append(placeholders, arg(columnOperand{value: value}))
+	}
+
+	return strings.Join(placeholders, ", ")
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type exprItem struct {
+	ID      int64           `repo:"pk"`
+	Name    string          `repo:""`
+	Note    *string         `repo:""`
+	Price   Decimal         `repo:""`
+	Ref     [16]byte        `repo:""`
+	Created time.Time       `repo:""`
+	Tags    []string        `repo:""`
+	Scores  []int32         `repo:""`
+	Meta    json.RawMessage `repo:""`
+	Raw     json.RawMessage `repo:"type=json"`
+}
+
+var exprRef = [16]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}
+
+const exprRefString = "12345678-9abc-def0-1234-56789abcdef0"
+
+// Условие WHERE и параметры SelectBy по одному фильтру
+func filterSQL(t *testing.T, filters Filters) (string, []interface{}) {
+	t.Helper()
+
+	cfg := mustStructConfig(t, exprItem{})
+	sql, args, err := (&QueryBuilder{}).SelectBy(cfg, reflect.TypeOf(exprItem{}), filters, 0, 0, nil)
+	if err != nil {
+		t.Fatalf("%v: %v", filters, err)
+	}
+
+	where := sql[strings.Index(sql, " WHERE ")+len(" WHERE "):]
+	where = where[:strings.Index(where, " ORDER BY ")]
+
+	for _, arg := range args {
+		if _, err := driver.DefaultParameterConverter.ConvertValue(arg); err != nil {
+			if _, ok := arg.(driver.Valuer); !ok {
+				t.Errorf("%v: argument %#v is not supported by drivers: %v", filters, arg, err)
+			}
+		}
+	}
+
+	return where, args
+}
+
+func TestFilterExpressions(t *testing.T) {
+	price, _ := NewDecimal("10.50")
+	created := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		filters Filters
+		where   string
+		args    []interface{}
+	}{
+		{Filters{"Name": "a"}, `"m0_"."name" = $1`, []interface{}{"a"}},
+		{Filters{"name": "a"}, `"m0_"."name" = $1`, []interface{}{"a"}},
+		{Filters{"Note": nil}, `"m0_"."note" IS NULL`, nil},
+		{Filters{"ID": [2]int64{1, 5}}, `"m0_"."id" BETWEEN $1 AND $2`, []interface{}{int64(1), int64(5)}},
+		{Filters{"ID": &IN{Ids: []int64{1, 2}}}, `"m0_"."id" IN ($1, $2)`, []interface{}{int64(1), int64(2)}},
+		{Filters{"ID": &IN{}}, `FALSE`, nil},
+		{Filters{"Note": &IN{Values: []interface{}{"x"}, OrIsNull: true}}, `("m0_"."note" IN ($1) OR "m0_"."note" IS NULL)`, []interface{}{"x"}},
+		{Filters{"ID": NotIn(int64(3))}, `"m0_"."id" NOT IN ($1)`, []interface{}{int64(3)}},
+		{Filters{"Note": IsNotNull{}}, `"m0_"."note" IS NOT NULL`, nil},
+		{Filters{"Name": ILIKE{Pattern: "a%"}}, `"m0_"."name" ILIKE $1`, []interface{}{"a%"}},
+		{Filters{"Price": GT{Value: price}}, `"m0_"."price" > $1`, []interface{}{"10.50"}},
+		{Filters{"Price": LTE{Value: &price}}, `"m0_"."price" <= $1`, []interface{}{"10.50"}},
+		{Filters{"Ref": In(exprRef)}, `"m0_"."ref" IN ($1)`, []interface{}{exprRefString}},
+		{Filters{"Ref": exprRef}, `"m0_"."ref" = $1`, []interface{}{exprRefString}},
+		{Filters{"Created": GTE{Value: created}}, `"m0_"."created" >= $1`, []interface{}{created}},
+		{Filters{"Name": NEQ{Value: ""}}, `"m0_"."name" <> $1`, []interface{}{""}},
+		{Filters{"Scores": ANY{Value: 5}}, `$1 = ANY("m0_"."scores")`, []interface{}{5}},
+		{Filters{"Tags": ArrayContains{Values: []string{"a"}}}, `"m0_"."tags" @> $1`, []interface{}{arrayArg{[]string{"a"}}}},
+		{Filters{"Tags": ArrayOverlaps{Values: []string{"a"}}}, `"m0_"."tags" && $1`, []interface{}{arrayArg{[]string{"a"}}}},
+		{Filters{"Meta": JSONHasKey{Key: "k"}}, `"m0_"."meta" ? $1`, []interface{}{"k"}},
+		{Filters{"Meta": JSONPathEQ{Path: []string{"a", "b"}, Value: 1}},
+			`("m0_"."meta" -> $1::text ->> $2::text) = $3`, []interface{}{"a", "b", "1"}},
+		{Filters{"Meta": JSONContains{Value: map[string]int{"a": 1}}}, `"m0_"."meta" @> $1::jsonb`, []interface{}{jsonArg{map[string]int{"a": 1}}}},
+		{Filters{"Raw": JSONContains{Value: map[string]int{"a": 1}}}, `"m0_"."raw"::jsonb @> $1::jsonb`, []interface{}{jsonArg{map[string]int{"a": 1}}}},
+		{Filters{"Raw": &JSONHasKey{Key: "k"}}, `"m0_"."raw"::jsonb ? $1`, []interface{}{"k"}},
+		{Filters{"Raw": JSONPathEQ{Path: []string{"a"}, Value: 1}}, `("m0_"."raw" ->> $1::text) = $2`, []interface{}{"a", "1"}},
+		{Where(Or(Filters{"Name": "a"}, Filters{"Note": nil})), `("m0_"."name" = $1 OR "m0_"."note" IS NULL)`, []interface{}{"a"}},
+		{Where(Not(And(Filters{"Name": "a", "ID": int64(1)}))), `NOT (("m0_"."id" = $1 AND "m0_"."name" = $2))`, []interface{}{int64(1), "a"}},
+		{Where(Or(Filters{"Name": "a"}, Filters{"Name": "b"}), Not(Filters{"Note": nil})),
+			`(("m0_"."name" = $1 OR "m0_"."name" = $2) AND NOT ("m0_"."note" IS NULL))`, []interface{}{"a", "b"}},
+		{Filters{FILTER_EXPRESSION_KEY: Filters{"Name": "a"}, "ID": int64(1)}, `"m0_"."name" = $1 AND "m0_"."id" = $2`, []interface{}{"a", int64(1)}},
+	}
+
+	for _, c := range cases {
+		where, args := filterSQL(t, c.filters)
+		if where != c.where || !reflect.DeepEqual(args, c.args) {
+			t.Errorf("%v:\n got %s %#v\nwant %s %#v", c.filters, where, args, c.where, c.args)
+		}
+	}
+}
+
+func TestInvalidFilters(t *testing.T) {
+	cfg := mustStructConfig(t, exprItem{})
+	qb := &QueryBuilder{}
+
+	for _, filters := range []Filters{
+		{"Nmae": "x"},
+		{"Owner.Name": "x"},
+		Where(Or(Filters{"Name": "a"}, Filters{"Nmae": "b"})),
+		Where(Or()),
+		Where(And(Filters{})),
+		Where(),
+		{"Name": Or(Filters{"Name": "a"}, Filters{"Name": "b"})},
+		{FILTER_EXPRESSION_KEY: "a"},
+		{"ID": [2]int64{}, "Name": [3]string{}},
+	} {
+		if _, _, err := qb.SelectBy(cfg, reflect.TypeOf(exprItem{}), filters, 0, 0, nil); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("SelectBy %v: expected ErrInvalidFilter, got %v", filters, err)
+		}
+
+		if _, _, err := qb.DeleteBy(cfg, reflect.TypeOf(exprItem{}), filters); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("DeleteBy %v: expected ErrInvalidFilter, got %v", filters, err)
+		}
+	}
+
+	if _, _, err := qb.SelectBy(cfg, reflect.TypeOf(exprItem{}), nil, 0, 0, []Order{Asc("Nmae")}); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("expected ErrInvalidFilter for unknown order field, got %v", err)
+	}
+}
+
+func TestDecimalValuer(t *testing.T) {
+	d, err := NewDecimal("-1.25e3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := d.Value(); err != nil || value != "-1.25e3" {
+		t.Errorf("Value = %v, %v", value, err)
+	}
+
+	for src, expected := range map[interface{}]string{"1.5": "1.5", int64(7): "7", float64(0.25): "0.25"} {
+		var scanned Decimal
+		if err := scanned.Scan(src); err != nil || scanned.String() != expected {
+			t.Errorf("Scan(%v) = %s, %v", src, scanned, err)
+		}
+	}
+
+	var scanned Decimal
+	if err := scanned.Scan([]byte("abc")); err == nil {
+		t.Errorf("expected error for invalid decimal")
+	}
+
+	qb := &QueryBuilder{}
+	if value, _ := qb.prepareValueForSQL("numeric", Decimal{}, true, false); value != nil {
+		t.Errorf("zero Decimal in nullable column must be NULL, got %v", value)
+	}
+	if value, _ := qb.prepareValueForSQL("numeric", Decimal{}, false, false); value != "0" {
+		t.Errorf("zero Decimal in not null column must be 0, got %v", value)
+	}
+}
@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Драйвер для тестов без БД: запоминает запросы, отдаёт строки из очереди rows,
+// результат Exec и ошибки задаются функциями
+type fakeDb struct {
+	queries []string
+	args    [][]interface{}
+	rows    []fakeRows
+	// Ошибка запроса; nil - запрос выполняется
+	fail func(query string, args []interface{}) error
+	// Количество изменённых строк Exec; по умолчанию 1
+	affected func(query string, args []interface{}) int64
+	commits  int
+	rollback int
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func newFakeDb(t *testing.T) (*fakeDb, *sql.DB) {
+	f := &fakeDb{}
+	db := sql.OpenDB(f)
+	t.Cleanup(func() { db.Close() })
+
+	return f, db
+}
+
+func (f *fakeDb) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: f}, nil
+}
+
+func (f *fakeDb) Driver() driver.Driver {
+	return nil
+}
+
+func (f *fakeDb) addRows(columns []string, values ...[]driver.Value) {
+	f.rows = append(f.rows, fakeRows{columns: columns, values: values})
+}
+
+// Запросы, начинающиеся с prefix
+func (f *fakeDb) queriesWithPrefix(prefix string) []string {
+	result := []string{}
+	for _, query := range f.queries {
+		if strings.HasPrefix(query, prefix) {
+			result = append(result, query)
+		}
+	}
+
+	return result
+}
+
+func (f *fakeDb) run(query string, named []driver.NamedValue) error {
+	args := make([]interface{}, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+
+	f.queries = append(f.queries, query)
+	f.args = append(f.args, args)
+
+	if f.fail != nil {
+		return f.fail(query, args)
+	}
+
+	return nil
+}
+
+// Как и у драйверов Postgres, запрос при открытой выборке соединения - ошибка
+type fakeConn struct {
+	db   *fakeDb
+	open *fakeDriverRows
+}
+
+var errFakeConnBusy = errors.New("fake: connection is busy with open rows")
+
+func (c *fakeConn) busy() bool {
+	return c.open != nil && !c.open.closed
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.db.run("BEGIN", nil); err != nil {
+		return nil, err
+	}
+
+	return &fakeTx{db: c.db}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.busy() {
+		return nil, errFakeConnBusy
+	}
+
+	if err := c.db.run(query, args); err != nil {
+		return nil, err
+	}
+
+	affected := int64(1)
+	if c.db.affected != nil {
+		affected = c.db.affected(query, c.db.args[len(c.db.args)-1])
+	}
+
+	return driver.RowsAffected(affected), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.busy() {
+		return nil, errFakeConnBusy
+	}
+
+	if err := c.db.run(query, args); err != nil {
+		return nil, err
+	}
+
+	rows := &fakeDriverRows{}
+	if len(c.db.rows) > 0 {
+		rows.rows = c.db.rows[0]
+		c.db.rows = c.db.rows[1:]
+	}
+	c.open = rows
+
+	return rows, nil
+}
+
+type fakeTx struct {
+	db *fakeDb
+}
+
+func (tx *fakeTx) Commit() error {
+	if err := tx.db.run("COMMIT", nil); err != nil {
+		return err
+	}
+	tx.db.commits++
+
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.db.rollback++
+
+	return tx.db.run("ROLLBACK", nil)
+}
+
+type fakeDriverRows struct {
+	rows   fakeRows
+	i      int
+	closed bool
+}
+
+func (r *fakeDriverRows) Columns() []string {
+	return r.rows.columns
+}
+
+func (r *fakeDriverRows) Close() error {
+	r.closed = true
+
+	return nil
+}
+
+func (r *fakeDriverRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows.values) {
+		return io.EOF
+	}
+
+	copy(dest, r.rows.values[r.i])
+	r.i++
+
+	return nil
+}
+
+// Ошибка Postgres с SQLSTATE, как её отдают драйверы
+type fakePqError struct {
+	Code       string
+	Constraint string
+}
+
+func (e *fakePqError) Error() string {
+	return "pq: error " + e.Code
+}
+
+func (e *fakePqError) SQLState() string {
+	return e.Code
+}
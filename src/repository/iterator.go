@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+)
+
+// Количество записей итератора, связи которых загружаются вместе, одним запросом на связь
+const ITERATOR_BATCH_SIZE = 100
+
+// Обход выборки без загрузки всех записей в память: записи читаются пачками по ITERATOR_BATCH_SIZE.
+// Вне транзакции выборка - один запрос, связи пачки загружаются, пока он открыт.
+// У транзакции одно соединение, поэтому в ней каждая пачка - отдельный запрос с LIMIT/OFFSET,
+// закрываемый до загрузки связей и до обработки записей: внутри обхода можно выполнять запросы в той же транзакции,
+// но изменение отобранных записей может сдвинуть следующие пачки
+type EntityIterator struct {
+	ctx    context.Context
+	repo   *AbstractRepo
+	rows   *sql.Rows
+	query  *iteratorQuery
+	batch  []interface{}
+	entity interface{}
+	err    error
+}
+
+// Параметры выборки итератора в транзакции; limit 0 - без ограничения
+type iteratorQuery struct {
+	filters map[string]interface{}
+	orderBy []Order
+	limit   int
+	offset  int
+	done    bool
+}
+
+func (it *EntityIterator) Next() bool {
+	if len(it.batch) == 0 && !it.fetch() {
+		return false
+	}
+
+	it.entity = it.batch[0]
+	it.batch = it.batch[1:]
+
+	return true
+}
+
+// Читает следующую пачку записей и загружает её связи
+func (it *EntityIterator) fetch() bool {
+	if it.rows == nil && it.query != nil && !it.query.done {
+		if err := it.queryBatch(); err != nil {
+			it.fail(err)
+			return false
+		}
+	}
+
+	if it.rows == nil {
+		return false
+	}
+
+	cfg := it.repo.config
+	objects := []interface{}{}
+	keys := []map[string]sql.NullInt64{}
+	for len(objects) < ITERATOR_BATCH_SIZE && it.rows.Next() {
+		object := reflect.New(it.repo.reflectType).Interface()
+		objectKeys, err := it.repo.scanRecordData(object, cfg, it.rows)
+		if err != nil {
+			it.fail(err)
+			return false
+		}
+
+		objects = append(objects, object)
+		keys = append(keys, objectKeys)
+	}
+
+	// Выборка прочитана полностью или это запрос пачки: соединение освобождается до загрузки связей
+	if len(objects) < ITERATOR_BATCH_SIZE || it.query != nil {
+		if err := it.rows.Err(); err != nil {
+			it.fail(err)
+			return false
+		}
+
+		it.closeRows()
+	}
+
+	if len(objects) < ITERATOR_BATCH_SIZE && it.query != nil {
+		it.query.done = true
+	}
+
+	if len(objects) == 0 {
+		return false
+	}
+
+	if err := it.repo.fillRecordsDataRelations(it.ctx, objects, keys, cfg); err != nil {
+		it.fail(err)
+		return false
+	}
+
+	for i, object := range objects {
+		objects[i] = it.repo.managed(object)
+	}
+	it.batch = objects
+
+	return true
+}
+
+// Запрос следующей пачки выборки в транзакции
+func (it *EntityIterator) queryBatch() error {
+	q := it.query
+
+	size := ITERATOR_BATCH_SIZE
+	if q.limit > 0 && q.limit < size {
+		size = q.limit
+	}
+
+	query, args, err := it.repo.qb.SelectBy(it.repo.config, it.repo.reflectType, q.filters, size, q.offset, q.orderBy)
+	if err != nil {
+		return err
+	}
+
+	rows, err := it.repo.db.QueryContext(it.ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	it.rows = rows
+	q.offset += size
+	if q.limit > 0 {
+		q.limit -= size
+		q.done = q.limit == 0
+	}
+
+	return nil
+}
+
+func (it *EntityIterator) fail(err error) {
+	it.err = err
+	it.Close()
+}
+
+func (it *EntityIterator) Entity() interface{} {
+	return it.entity
+}
+
+func (it *EntityIterator) Err() error {
+	return it.err
+}
+
+func (it *EntityIterator) Close() error {
+	it.batch = nil
+	it.entity = nil
+	it.query = nil
+
+	return it.closeRows()
+}
+
+func (it *EntityIterator) closeRows() error {
+	if it.rows == nil {
+		return nil
+	}
+
+	rows := it.rows
+	it.rows = nil
+
+	return rows.Close()
+}
+
+func (a *AbstractRepo) FindIterator(filters map[string]interface{}, orderBy []Order, limit int, offset int) (*EntityIterator, error) {
+	return a.FindIteratorCtx(context.Background(), filters, orderBy, limit, offset)
+}
+
+func (a *AbstractRepo) FindIteratorCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order, limit int, offset int) (*EntityIterator, error) {
+	switch a.db.(type) {
+	case *Tx, *sql.Tx:
+		it := &EntityIterator{ctx: ctx, repo: a, query: &iteratorQuery{filters: filters, orderBy: orderBy, limit: limit, offset: offset}}
+		if err := it.queryBatch(); err != nil {
+			return nil, err
+		}
+
+		return it, nil
+	}
+
+	query, args, err := a.qb.SelectBy(a.config, a.reflectType, filters, limit, offset, orderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EntityIterator{ctx: ctx, repo: a, rows: rows}, nil
+}
+
+// Вызывает fn для каждой записи выборки; ошибка fn останавливает обход и возвращается
+func (a *AbstractRepo) Iterate(filters map[string]interface{}, orderBy []Order, fn func(entity interface{}) error) error {
+	return a.IterateCtx(context.Background(), filters, orderBy, fn)
+}
+
+func (a *AbstractRepo) IterateCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order, fn func(entity interface{}) error) error {
+	it, err := a.FindIteratorCtx(ctx, filters, orderBy, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	defer it.Close()
+
+	for it.Next() {
+		if err := fn(it.Entity()); err != nil {
+			return err
+		}
+	}
+
+	return it.Err()
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+type iterGroup struct {
+	ID    int64  `repo:"pk"`
+	Title string `repo:""`
+}
+
+type iterUser struct {
+	ID    int64      `repo:"pk"`
+	Name  string     `repo:""`
+	Group *iterGroup `repo:"rel=many_to_one,fk=group_id"`
+}
+
+func TestIteratorLoadsRelationsPerBatch(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[iterUser](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := ITERATOR_BATCH_SIZE + ITERATOR_BATCH_SIZE/2
+	users := [][]driver.Value{}
+	for i := 1; i <= count; i++ {
+		users = append(users, []driver.Value{int64(i), "u", int64(i%2 + 1)})
+	}
+	f.addRows([]string{"id", "name", "group_id"}, users...)
+	groups := [][]driver.Value{{int64(1), "a"}, {int64(2), "b"}}
+	f.addRows([]string{"id", "title"}, groups...)
+	f.addRows([]string{"id", "title"}, groups...)
+
+	seen := 0
+	err = repo.Iterate(nil, nil, func(user *iterUser) error {
+		seen++
+		if user.Group == nil || user.Group.ID != user.ID%2+1 {
+			t.Fatalf("relation not loaded for user %d: %+v", user.ID, user.Group)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if seen != count {
+		t.Errorf("iterated %d entities, expected %d", seen, count)
+	}
+
+	if n := len(f.queriesWithPrefix(`SELECT "m0_"."id", "m0_"."title"`)); n != 2 {
+		t.Errorf("expected one relation query per batch, got %d", n)
+	}
+}
+
+// Строки пользователей с id from..to, группа - 1 или 2
+func iterUserRows(from, to int) [][]driver.Value {
+	users := [][]driver.Value{}
+	for i := from; i <= to; i++ {
+		users = append(users, []driver.Value{int64(i), "u", int64(i%2 + 1)})
+	}
+
+	return users
+}
+
+func TestIteratorInTransaction(t *testing.T) {
+	f, db := newFakeDb(t)
+	ctx := context.Background()
+
+	users, err := NewTypedRepoFromStruct[iterUser](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userColumns := []string{"id", "name", "group_id"}
+	groups := [][]driver.Value{{int64(1), "a"}, {int64(2), "b"}}
+	f.addRows(userColumns, iterUserRows(1, ITERATOR_BATCH_SIZE)...)
+	f.addRows([]string{"id", "title"}, groups...)
+	f.addRows(userColumns, iterUserRows(ITERATOR_BATCH_SIZE+1, ITERATOR_BATCH_SIZE+10)...)
+	f.addRows([]string{"id", "title"}, groups...)
+
+	seen := 0
+	err = RunInTx(ctx, db, func(tx *Tx) error {
+		txUsers := users.WithTx(tx)
+		return txUsers.IterateCtx(ctx, nil, nil, func(user *iterUser) error {
+			seen++
+			if user.Group == nil || user.Group.ID != user.ID%2+1 {
+				t.Fatalf("relation not loaded for user %d: %+v", user.ID, user.Group)
+			}
+
+			// Запросы в той же транзакции внутри обхода
+			if user.ID == 1 {
+				user.Name = "v"
+				return txUsers.UpdateCtx(ctx, user)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if seen != ITERATOR_BATCH_SIZE+10 {
+		t.Errorf("iterated %d entities, expected %d", seen, ITERATOR_BATCH_SIZE+10)
+	}
+
+	if n := len(f.queriesWithPrefix("UPDATE")); n != 1 {
+		t.Errorf("expected UPDATE inside iteration, got %q", f.queries)
+	}
+
+	selects := f.queriesWithPrefix(`SELECT "m0_"."id", "m0_"."name"`)
+	if len(selects) != 2 || !strings.HasSuffix(selects[0], "LIMIT 100") || !strings.HasSuffix(selects[1], "LIMIT 100 OFFSET 100") {
+		t.Errorf("expected one query per batch, got %q", selects)
+	}
+}
+
+func TestIteratorInTransactionLimit(t *testing.T) {
+	f, db := newFakeDb(t)
+	ctx := context.Background()
+
+	groups, err := NewTypedRepoFromStruct[iterGroup](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groupRows := func(from, to int) [][]driver.Value {
+		rows := [][]driver.Value{}
+		for i := from; i <= to; i++ {
+			rows = append(rows, []driver.Value{int64(i), "g"})
+		}
+		return rows
+	}
+
+	f.addRows([]string{"id", "title"}, groupRows(1, ITERATOR_BATCH_SIZE)...)
+	f.addRows([]string{"id", "title"}, groupRows(ITERATOR_BATCH_SIZE+1, 2*ITERATOR_BATCH_SIZE)...)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	it, err := groups.Repo().WithTx(tx).FindIteratorCtx(ctx, nil, nil, 2*ITERATOR_BATCH_SIZE, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	seen := 0
+	for it.Next() {
+		seen++
+	}
+	if it.Err() != nil || seen != 2*ITERATOR_BATCH_SIZE {
+		t.Errorf("iterated %d entities, %v", seen, it.Err())
+	}
+
+	selects := f.queriesWithPrefix("SELECT")
+	if len(selects) != 2 || !strings.HasSuffix(selects[0], "LIMIT 100 OFFSET 10") || !strings.HasSuffix(selects[1], "LIMIT 100 OFFSET 110") {
+		t.Errorf("expected two batch queries within limit, got %q", selects)
+	}
+}
@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Условие keyset-пагинации: записи строго после значений курсора в порядке сортировки
+type keysetExpr struct {
+	columns []string
+	desc    []bool
+	values  []interface{}
+}
+
+// Содержимое курсора: колонки сортировки и значения последней записи страницы
+type keysetCursor struct {
+	Columns []string          `json:"c"`
+	Values  []json.RawMessage `json:"v"`
+}
+
+// Колонки keyset-сортировки: колонки orderBy основной таблицы и первичный ключ для однозначности порядка
+func (qb *QueryBuilder) keysetOrder(cfg *TableConfig, t reflect.Type, orderBy []Order) ([]Order, []string, error) {
+	fields, _ := GetTableColumnMap(cfg, t)
+
+	orders := []Order{}
+	columns := []string{}
+	pkFound := false
+	for _, order := range orderBy {
+		colName := qb.filterColumn(cfg, fields, order.Field)
+		if order.Field == cfg.PK {
+			colName = cfg.PK
+		}
+
+		if colName == "" {
+			return nil, nil, fmt.Errorf("%w: keyset order field must be a column of table %s: %s", ErrInvalidFilter, cfg.TableName, order.Field)
+		}
+
+		if colName != cfg.PK && cfg.TableColumns[colName].Nullable {
+			return nil, nil, fmt.Errorf("%w: keyset order column must not be nullable: %s", ErrInvalidFilter, colName)
+		}
+
+		if colName == cfg.PK {
+			pkFound = true
+		}
+
+		orders = append(orders, Order{Field: colName, Desc: order.Desc})
+		columns = append(columns, colName)
+	}
+
+	if !pkFound {
+		desc := false
+		if len(orders) > 0 {
+			desc = orders[len(orders)-1].Desc
+		}
+
+		orders = append(orders, Order{Field: cfg.PK, Desc: desc})
+		columns = append(columns, cfg.PK)
+	}
+
+	return orders, columns, nil
+}
+
+// (c1, c2) > ($1, $2) при одном направлении сортировки, иначе развёрнутое условие через OR
+func (qb *QueryBuilder) keysetSQL(e *keysetExpr, args *queryArgs) string {
+	m0 := MAIN_TABLE_ALIAS
+
+	columns := []string{}
+	placeholders := []string{}
+	sameDirection := true
+	for i, colName := range e.columns {
+		columns = append(columns, "\""+m0+"\".\""+colName+"\"")
+		placeholders = append(placeholders, args.add(e.values[i]))
+		if e.desc[i] != e.desc[0] {
+			sameDirection = false
+		}
+	}
+
+	if sameDirection {
+		op := " > "
+		if e.desc[0] {
+			op = " < "
+		}
+
+		return "(" + strings.Join(columns, ", ") + ")" + op + "(" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	condition := ""
+	for i := len(columns) - 1; i >= 0; i-- {
+		op := " > "
+		if e.desc[i] {
+			op = " < "
+		}
+
+		if condition == "" {
+			condition = columns[i] + op + placeholders[i]
+		} else {
+			condition = "(" + columns[i] + op + placeholders[i] + " OR (" + columns[i] + " = " + placeholders[i] + " AND " + condition + "))"
+		}
+	}
+
+	return condition
+}
+
+func encodeKeysetCursor(cfg *TableConfig, object interface{}, columns []string) (string, error) {
+	value := reflect.Indirect(reflect.ValueOf(object))
+	fields, _ := GetTableColumnMap(cfg, value.Type())
+
+	cursor := keysetCursor{Columns: columns}
+	for _, colName := range columns {
+		fieldName, ok := fields[colName]
+		if !ok {
+			return "", fmt.Errorf("%w: column %s of table %s in type %s", ErrFieldNotMapped, colName, cfg.TableName, value.Type().Name())
+		}
+
+		data, err := json.Marshal(value.FieldByName(fieldName).Interface())
+		if err != nil {
+			return "", err
+		}
+
+		cursor.Values = append(cursor.Values, data)
+	}
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Значения курсора, приведённые к типам колонок; курсор должен быть выдан для той же сортировки
+func decodeKeysetCursor(cfg *TableConfig, token string, columns []string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := keysetCursor{}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if len(cursor.Columns) != len(columns) || len(cursor.Values) != len(columns) {
+		return nil, ErrInvalidCursor
+	}
+
+	values := []interface{}{}
+	for i, colName := range columns {
+		if cursor.Columns[i] != colName {
+			return nil, ErrInvalidCursor
+		}
+
+		var value interface{}
+		switch cfg.TableColumns[colName].Type {
+		case "int", "int2", "int4", "int8":
+			var v int64
+			err = json.Unmarshal(cursor.Values[i], &v)
+			value = v
+		case "float4", "float64":
+			var v float64
+			err = json.Unmarshal(cursor.Values[i], &v)
+			value = v
+		case "timestamp", "timestamptz", "date":
+			var v time.Time
+			err = json.Unmarshal(cursor.Values[i], &v)
+			value = v
+		case "numeric":
+			var v Decimal
+			err = json.Unmarshal(cursor.Values[i], &v)
+			value = v.String()
+		case "uuid":
+			var v string
+			if err = json.Unmarshal(cursor.Values[i], &v); err != nil {
+				var u [16]byte
+				err = json.Unmarshal(cursor.Values[i], &u)
+				v = formatUUID(u)
+			}
+			value = v
+		case "bool":
+			var v bool
+			err = json.Unmarshal(cursor.Values[i], &v)
+			value = v
+		default:
+			var v string
+			err = json.Unmarshal(cursor.Values[i], &v)
+			value = v
+		}
+
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,40 @@
+package repository
+
+// Положение NULL в сортировке; по умолчанию - как решит Postgres (NULLS LAST для ASC, NULLS FIRST для DESC)
+type NullsOrder int
+
+const (
+	NullsDefault NullsOrder = iota
+	NullsFirst
+	NullsLast
+)
+
+// Сортировка выборки по полю сущности (Field), имени колонки или полю связи one_to_one/many_to_one ("связь.Поле")
+type Order struct {
+	Field string
+	Desc  bool
+	Nulls NullsOrder
+}
+
+func Asc(field string) Order {
+	return Order{Field: field}
+}
+
+func Desc(field string) Order {
+	return Order{Field: field, Desc: true}
+}
+
+func (o Order) NullsFirst() Order {
+	o.Nulls = NullsFirst
+	return o
+}
+
+func (o Order) NullsLast() Order {
+	o.Nulls = NullsLast
+	return o
+}
+
+// Сортировка по первичному ключу, эквивалент прежнего параметра asc
+func OrderByPK(cfg *TableConfig, asc bool) []Order {
+	return []Order{{Field: cfg.PK, Desc: !asc}}
+}
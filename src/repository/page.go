@@ -0,0 +1,45 @@
+package repository
+
+import "errors"
+
+// Страница выборки, Number начинается с 1
+type Page struct {
+	Number int
+	Size   int
+}
+
+type PageResult struct {
+	Items  []interface{}
+	Total  int64
+	Number int
+	Size   int
+}
+
+func (p Page) validate() error {
+	if p.Number < 1 {
+		return errors.New("Page number must be greater than 0")
+	}
+
+	if p.Size < 1 {
+		return errors.New("Page size must be greater than 0")
+	}
+
+	return nil
+}
+
+func (p Page) Offset() int {
+	return (p.Number - 1) * p.Size
+}
+
+// Количество страниц при текущем размере страницы
+func (r *PageResult) Pages() int {
+	return pageCount(r.Total, r.Size)
+}
+
+func pageCount(total int64, size int) int {
+	if size < 1 {
+		return 0
+	}
+
+	return int((total + int64(size) - 1) / int64(size))
+}
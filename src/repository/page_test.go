@@ -0,0 +1,33 @@
+package repository
+
+import "testing"
+
+func TestPage(t *testing.T) {
+	if offset := (Page{Number: 3, Size: 20}).Offset(); offset != 40 {
+		t.Errorf("Offset = %d", offset)
+	}
+
+	for _, page := range []Page{{Number: 0, Size: 10}, {Number: 1, Size: 0}} {
+		if err := page.validate(); err == nil {
+			t.Errorf("%+v: expected error", page)
+		}
+	}
+
+	cases := []struct {
+		total int64
+		size  int
+		pages int
+	}{
+		{0, 10, 0},
+		{1, 10, 1},
+		{10, 10, 1},
+		{11, 10, 2},
+		{5, 0, 0},
+	}
+
+	for _, c := range cases {
+		if pages := (&PageResult{Total: c.total, Size: c.size}).Pages(); pages != c.pages {
+			t.Errorf("total %d, size %d: Pages = %d, expected %d", c.total, c.size, pages, c.pages)
+		}
+	}
+}
@@ -2,178 +2,265 @@ package repository
 
 import (
 	"bitbucket.org/pkg/inflect"
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 const MAIN_TABLE_ALIAS = "m0_"
 
-type inExpressionInterface interface {
-	AddValue(interface{})
-	GetOrIsNull() bool
-	ToString() string
+type QueryBuilder struct {
+	// Не исключать из выборок записи, помеченные как удалённые (soft_delete)
+	IncludeDeleted bool
 }
 
-type QueryBuilder struct {
+// queryArgs собирает значения параметров запроса и выдаёт для них плейсхолдеры $1, $2...
+type queryArgs struct {
+	values []interface{}
 }
 
-func (qb *QueryBuilder) escapeValueForSQL(typeStr string, value interface{}, nullable bool, zeroToNull bool) string {
+func (a *queryArgs) add(value interface{}) string {
+	a.values = append(a.values, value)
+	return "$" + strconv.Itoa(len(a.values))
+}
+
+// Значение поля для запроса по типу колонки. driver.Valuer передаётся как есть, nil-указатель - NULL,
+// а значение по указателю записывается без замены пустых и нулевых значений на NULL
+func (qb *QueryBuilder) prepareValueForSQL(typeStr string, value interface{}, nullable bool, zeroToNull bool) (interface{}, error) {
+
+	// Нулевой Decimal в nullable-колонке записывается как NULL, хотя Decimal реализует driver.Valuer
+	if d, ok := value.(Decimal); ok {
+		return typedValueForSQL(typeStr, d, nullable), nil
+	}
+
+	if result, ok, err := valuerValue(value); ok {
+		return result, err
+	}
+
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr {
+		return qb.prepareValueForSQL(typeStr, v.Elem().Interface(), false, false)
+	}
+
+	if elemType, ok := arrayElementType(typeStr); ok {
+		return qb.arrayValueForSQL(elemType, value, nullable)
+	}
 
 	switch typeStr {
 	case "string":
 		if value == "" && nullable {
-			return "null"
+			return nil, nil
+		}
+		if value == nil {
+			return nil, nil
 		}
-		return "'" + fmt.Sprint(value) + "'"
+		return fmt.Sprint(value), nil
 	case "int", "int2", "int4", "int8":
 		if value == nil && nullable {
-			return "null"
+			return nil, nil
 		}
 
 		if zeroToNull && fmt.Sprint(value) == "0" {
 			if !nullable {
-				panic("Int value is converting to null, but nullable field param is not set")
+				return nil, errors.New("Int value is converting to null, but nullable field param is not set")
 			}
 
-			return "null"
+			return nil, nil
 		}
 
-		return fmt.Sprint(value)
+		return value, nil
 
 	case "bool":
-		if value.(bool) == true {
-			return "true"
+		if b, ok := value.(bool); ok && b {
+			return true, nil
 		}
-		return "false"
+		return false, nil
+	case "json", "jsonb":
+		return jsonValueForSQL(value, nullable)
 	}
 
-	return fmt.Sprint(value)
+	return typedValueForSQL(typeStr, value, nullable), nil
 }
 
-func (qb *QueryBuilder) Update(cfg *TableConfig, object interface{}) string {
-	var tableColumnLabels []string
-	var tableColumnValues []string
+// Значение колонки colName для запроса; ошибка конфигурации колонки возвращается как *ConfigError
+func (qb *QueryBuilder) columnValue(cfg *TableConfig, colName string, value interface{}) (interface{}, error) {
+	colCfg := cfg.TableColumns[colName]
 
-	t := reflect.Indirect(reflect.ValueOf(object))
-	fields, fieldsNotFound := GetTableColumnMap(cfg, reflect.TypeOf(object))
+	result, err := qb.prepareValueForSQL(colCfg.Type, value, colCfg.Nullable, colCfg.ZeroToNull)
+	if err != nil {
+		return nil, &ConfigError{Table: cfg.TableName, Dir: cfg.Dir, Err: fmt.Errorf("column %s: %w", colName, err)}
+	}
 
-	updateExpr := map[string]string{}
+	return result, nil
+}
 
-	var pkVal int64
+// Значение операнда выражения фильтра по колонке colName
+func (qb *QueryBuilder) operandValue(cfg *TableConfig, colName string, operand columnOperand) (interface{}, error) {
+	typeStr := cfg.TableColumns[colName].Type
+	if elemType, ok := arrayElementType(typeStr); ok && operand.element {
+		typeStr = elemType
+	}
 
-	for colName, colCfg := range cfg.TableColumns {
-		if colName == "id" {
-			pkVal = t.FieldByName("ID").Int()
-			continue
-		}
+	result, err := qb.prepareValueForSQL(typeStr, operand.value, false, false)
+	if err != nil {
+		return nil, &ConfigError{Table: cfg.TableName, Dir: cfg.Dir, Err: fmt.Errorf("column %s: %w", colName, err)}
+	}
 
-		colNotFound := false
-		for _, notFoundKey := range fieldsNotFound {
-			if notFoundKey == colName {
-				colNotFound = true
-				break
-			}
-		}
+	return result, nil
+}
 
-		if colNotFound {
-			continue
-		}
+// Поле сущности для колонки, обязательной для запроса (первичный ключ, версия)
+func requiredField(cfg *TableConfig, fields map[string]string, colName string, t reflect.Type) (string, error) {
+	fieldName, ok := fields[colName]
+	if !ok {
+		return "", fmt.Errorf("%w: column %s of table %s in type %s", ErrFieldNotMapped, colName, cfg.TableName, t.Name())
+	}
 
-		if classField, ok := fields[colName]; ok {
-			tableColumnLabels = append(tableColumnLabels, colName)
+	return fieldName, nil
+}
 
-			classFieldValue := t.FieldByName(classField).Interface()
+func (qb *QueryBuilder) Update(cfg *TableConfig, object interface{}) (string, []interface{}, error) {
+	return qb.UpdateColumns(cfg, object, nil)
+}
 
-			classFieldValueStr := qb.escapeValueForSQL(colCfg.Type, classFieldValue, colCfg.Nullable, colCfg.ZeroToNull)
+// UPDATE только перечисленных колонок; при onlyColumns == nil обновляются все колонки
+func (qb *QueryBuilder) UpdateColumns(cfg *TableConfig, object interface{}, onlyColumns []string) (string, []interface{}, error) {
+	args := &queryArgs{}
 
-			tableColumnValues = append(tableColumnValues, classFieldValueStr)
+	t := reflect.Indirect(reflect.ValueOf(object))
+	fields, _ := GetTableColumnMap(cfg, t.Type())
 
-			updateExpr[colName] = colName + " = " + classFieldValueStr
-		}
+	pkField, err := requiredField(cfg, fields, cfg.PK, t.Type())
+	if err != nil {
+		return "", nil, err
 	}
 
-	for relName, relCfg := range cfg.Relations {
-		if relCfg.Type == "one_to_one" || relCfg.Type == "many_to_one" {
-			if fk, ok := relCfg.Params["foreign_key"]; ok {
-				relValue := t.FieldByName(relName)
-				if !relValue.IsValid() {
-					continue
-				}
+	columns, values, err := qb.columnValues(cfg, object)
+	if err != nil {
+		return "", nil, err
+	}
+	if onlyColumns != nil {
+		columns = onlyColumns
+	}
 
-				if relValue.IsZero() {
-					updateExpr[fk.(string)] = fk.(string) + " = null"
-				} else {
-					if reflect.ValueOf(relValue).IsZero() {
-						updateExpr[fk.(string)] = fk.(string) + " = null"
-					} else {
-						far := reflect.Indirect(reflect.ValueOf(relValue))
-						if !far.IsZero() {
-							farKey := reflect.Indirect(reflect.ValueOf(relValue.Interface())).FieldByName("ID").Int()
-							updateExpr[fk.(string)] = fk.(string) + " = " + strconv.Itoa(int(farKey))
-						} else {
-							updateExpr[fk.(string)] = fk.(string) + " = null"
-						}
-					}
+	updates := []string{}
+	for _, colName := range columns {
+		if colName == cfg.Version {
+			continue
+		}
+		updates = append(updates, "\""+colName+"\" = "+args.add(values[colName]))
+	}
 
-				}
-			}
+	// Оптимистическая блокировка: версия увеличивается, а запись обновляется, только если версия не изменилась
+	whereStr := " WHERE \"" + cfg.PK + "\" = " + args.add(t.FieldByName(pkField).Interface())
+	if cfg.Version != "" {
+		versionField, err := requiredField(cfg, fields, cfg.Version, t.Type())
+		if err != nil {
+			return "", nil, err
 		}
+
+		updates = append(updates, "\""+cfg.Version+"\" = \""+cfg.Version+"\" + 1")
+		whereStr += " AND \"" + cfg.Version + "\" = " + args.add(t.FieldByName(versionField).Interface())
 	}
 
-	updates := []string{}
-	for _, expr := range updateExpr {
-		updates = append(updates, expr)
+	if len(updates) == 0 {
+		return "", nil, fmt.Errorf("%w: table %s", ErrNothingToUpdate, cfg.TableName)
 	}
 
-	sql := "UPDATE \"" + cfg.TableName + "\" SET " + strings.Join(updates, ", ") + " WHERE id = " + strconv.Itoa(int(pkVal))
+	sql := "UPDATE \"" + cfg.TableName + "\" SET " + strings.Join(updates, ", ") + whereStr
 
-	return sql
+	return sql, args.values, nil
 }
 
-func (qb *QueryBuilder) Insert(cfg *TableConfig, object interface{}) string {
-	var tableColumnLabels []string
+func (qb *QueryBuilder) Insert(cfg *TableConfig, object interface{}) (string, []interface{}, error) {
 	var tableColumnValues []string
+	args := &queryArgs{}
 
+	columns, values, err := qb.columnValues(cfg, object)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, colName := range columns {
+		tableColumnValues = append(tableColumnValues, args.add(values[colName]))
+	}
+
+	sql := "INSERT INTO \"" + cfg.TableName + "\" (\"" + strings.Join(columns, "\", \"") + "\") VALUES (" +
+		strings.Join(tableColumnValues, ", ") + ") RETURNING \"" + cfg.PK + "\""
+
+	return sql, args.values, nil
+}
+
+// Значения колонок записи для INSERT/UPDATE (кроме первичного ключа), включая внешние ключи связей one_to_one/many_to_one.
+// Внешний ключ берётся из ID связанной сущности; если связь не заполнена, остаётся значение колонки, а без колонки
+// внешний ключ не записывается
+func (qb *QueryBuilder) columnValues(cfg *TableConfig, object interface{}) ([]string, map[string]interface{}, error) {
 	t := reflect.Indirect(reflect.ValueOf(object))
-	fields, fieldsNotFound := GetTableColumnMap(cfg, reflect.TypeOf(object))
-	for colName, colCfg := range cfg.TableColumns {
-		if colName == "id" {
+	fields, _ := GetTableColumnMap(cfg, t.Type())
+	relations, _ := GetTableRelationMap(cfg, t.Type())
+
+	columns := []string{}
+	values := make(map[string]interface{})
+
+	for _, colName := range cfg.TableColumnsArr {
+		if colName == cfg.PK {
 			continue
 		}
 
-		colNotFound := false
-		for _, notFoundKey := range fieldsNotFound {
-			if notFoundKey == colName {
-				colNotFound = true
-				break
-			}
+		classField, ok := fields[colName]
+		if !ok {
+			continue
 		}
 
-		if colNotFound {
+		value, err := qb.columnValue(cfg, colName, t.FieldByName(classField).Interface())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		columns = append(columns, colName)
+		values[colName] = value
+	}
+
+	for _, relName := range cfg.RelationsArr {
+		relCfg := cfg.Relations[relName]
+		if relCfg.Type != "one_to_one" && relCfg.Type != "many_to_one" {
 			continue
 		}
 
-		if classField, ok := fields[colName]; ok {
-			tableColumnLabels = append(tableColumnLabels, colName)
+		fk, ok := relCfg.Params["foreign_key"]
+		if !ok {
+			continue
+		}
 
-			classFieldValue := t.FieldByName(classField).Interface()
+		fieldName, ok := relations[relName]
+		if !ok {
+			continue
+		}
 
-			classFieldValueStr := qb.escapeValueForSQL(colCfg.Type, classFieldValue, colCfg.Nullable, colCfg.ZeroToNull)
+		// Незагруженная связь (nil, в том числе удалённая через soft_delete цель) не меняет внешний ключ
+		relValue := reflect.Indirect(t.FieldByName(fieldName))
+		if !relValue.IsValid() {
+			continue
+		}
 
-			tableColumnValues = append(tableColumnValues, classFieldValueStr)
+		id, ok := entityId(relValue)
+		if !ok || id == 0 {
+			continue
 		}
-	}
 
-	sql := "INSERT INTO \"" + cfg.TableName + "\" (\"" + strings.Join(tableColumnLabels, "\", \"") + "\") VALUES (" +
-		strings.Join(tableColumnValues, ", ") + ") RETURNING id"
+		if _, ok := values[fk.(string)]; !ok {
+			columns = append(columns, fk.(string))
+		}
+		values[fk.(string)] = id
+	}
 
-	return sql
+	return columns, values, nil
 }
 
-func (qb *QueryBuilder) SelectById(cfg *TableConfig, t reflect.Type, id interface{}) string {
+func (qb *QueryBuilder) SelectById(cfg *TableConfig, t reflect.Type, id interface{}) (string, []interface{}) {
 	var tableColumns []string
+	args := &queryArgs{}
 
 	fields, notFound := GetTableColumnMap(cfg, t)
 	for _, colName := range cfg.TableColumnsArr {
@@ -195,30 +282,34 @@ func (qb *QueryBuilder) SelectById(cfg *TableConfig, t reflect.Type, id interfac
 		}
 	}
 
-	sql := "SELECT \"" + strings.Join(tableColumns, "\", \"") + "\" FROM \"" + cfg.TableName + "\" WHERE id = " + fmt.Sprint(id)
+	for _, relName := range GetTableRelationKeys(cfg, t) {
+		tableColumns = append(tableColumns, cfg.Relations[relName].Params["foreign_key"].(string))
+	}
+
+	sql := "SELECT \"" + strings.Join(tableColumns, "\", \"") + "\" FROM \"" + cfg.TableName + "\" WHERE \"" + cfg.PK + "\" = " + args.add(id)
+
+	if qb.excludeDeleted(cfg) {
+		sql += " AND \"" + cfg.SoftDelete + "\" IS NULL"
+	}
 
-	return sql
+	return sql, args.values
 }
 
-func (qb *QueryBuilder) SelectBy(cfg *TableConfig, t reflect.Type, filters map[string]interface{}, limit int, offset int, asc bool) string {
-	var tableColumns []string
-	var tableFilters []string
-	var tableJoins []string
+func (qb *QueryBuilder) SelectBy(cfg *TableConfig, t reflect.Type, filters map[string]interface{}, limit int, offset int, orderBy []Order) (string, []interface{}, error) {
+	return qb.selectBy(cfg, t, filters, nil, limit, offset, orderBy)
+}
 
-	if limit == 0 {
-		limit = 999999
-	}
+// SelectBy с условием keyset-пагинации; при keyset == nil - обычная выборка
+func (qb *QueryBuilder) selectBy(cfg *TableConfig, t reflect.Type, filters map[string]interface{}, keyset *keysetExpr, limit int, offset int, orderBy []Order) (string, []interface{}, error) {
+	var tableColumns []string
+	args := &queryArgs{}
 
 	m0 := MAIN_TABLE_ALIAS
 
-	filtersNotEmpty := false
 	fields, fieldsNotFound := GetTableColumnMap(cfg, t)
-	relations, _ := GetTableRelationMap(cfg, t)
 
 	for _, colName := range cfg.TableColumnsArr {
 
-		colCfg := cfg.TableColumns[colName]
-
 		colNotFound := false
 		for _, notFoundKey := range fieldsNotFound {
 			if notFoundKey == colName {
@@ -234,105 +325,522 @@ func (qb *QueryBuilder) SelectBy(cfg *TableConfig, t reflect.Type, filters map[s
 		if _, ok := fields[colName]; ok {
 			tableColumns = append(tableColumns, m0+"\".\""+colName)
 		}
+	}
 
-		for filterField, filterValue := range filters {
-
-			if inflect.Camelize(colName) == filterField {
-				filtersNotEmpty = true
-				if reflect.TypeOf(filterValue).Kind() == reflect.Array {
-					arr := reflect.ValueOf(&filterValue).Elem()
-					if reflect.ValueOf(filterValue).Len() == 2 {
-						array := arr.Interface().([2]string)
-
-						tableFilters = append(tableFilters, fmt.Sprintf("\""+m0+"\".\""+colName+"\" BETWEEN %s AND %s",
-							qb.escapeValueForSQL(colCfg.Type, array[0], colCfg.Nullable, colCfg.ZeroToNull),
-							qb.escapeValueForSQL(colCfg.Type, array[1], colCfg.Nullable, colCfg.ZeroToNull),
-						))
-					} else {
-						panic(fmt.Sprint("Некорректный массив в фильтрах: ", filterValue))
-					}
-				} else {
-
-					inter := reflect.TypeOf((*inExpressionInterface)(nil)).Elem()
-
-					if reflect.TypeOf(filterValue).Implements(inter) {
-						str := reflect.ValueOf(filterValue).MethodByName("ToString").Call([]reflect.Value{})
-						orIsNull := reflect.ValueOf(filterValue).MethodByName("GetOrIsNull").Call([]reflect.Value{})
-						if orIsNull[0].Interface().(bool) {
-							tableFilters = append(tableFilters, "(\""+m0+"\".\""+colName+"\" IN ("+fmt.Sprint(str[0])+") OR \""+m0+"\".\""+colName+"\" IS NULL)")
-						} else {
-							tableFilters = append(tableFilters, "\""+m0+"\".\""+colName+"\" IN ("+fmt.Sprint(str[0])+")")
-						}
-					} else if reflect.TypeOf(filterValue).String() == "*repository.IsNull" {
-						tableFilters = append(tableFilters, "\""+m0+"\".\""+colName+"\" IS NULL")
-					} else {
-						tableFilters = append(tableFilters, "\""+m0+"\".\""+colName+"\" = "+qb.escapeValueForSQL(colCfg.Type, filterValue, colCfg.Nullable, colCfg.ZeroToNull))
-					}
+	for _, relName := range GetTableRelationKeys(cfg, t) {
+		tableColumns = append(tableColumns, m0+"\".\""+cfg.Relations[relName].Params["foreign_key"].(string))
+	}
 
-				}
+	joins := &queryJoins{}
+	filtersStr, err := qb.filtersSQL(cfg, t, filters, keyset, args, joins)
+	if err != nil {
+		return "", nil, err
+	}
+
+	orderByStr, err := qb.orderBySQL(cfg, t, orderBy, joins)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sql := "SELECT \"" + strings.Join(tableColumns, "\", \"") + "\" FROM \"" + cfg.TableName + "\" AS \"" +
+		m0 + "\" " + joins.String() + " " + filtersStr + orderByStr
+
+	if limit > 0 {
+		sql += " LIMIT " + strconv.Itoa(limit)
+	}
+
+	if offset > 0 {
+		sql += " OFFSET " + strconv.Itoa(offset)
+	}
+
+	return sql, args.values, nil
+}
+
+func (qb *QueryBuilder) Count(cfg *TableConfig, t reflect.Type, filters map[string]interface{}) (string, []interface{}, error) {
+	args := &queryArgs{}
+
+	joins := &queryJoins{}
+	filtersStr, err := qb.filtersSQL(cfg, t, filters, nil, args, joins)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sql := "SELECT COUNT(*) FROM \"" + cfg.TableName + "\" AS \"" + MAIN_TABLE_ALIAS + "\" " + joins.String() + " " + filtersStr
+
+	return sql, args.values, nil
+}
+
+func (qb *QueryBuilder) Delete(cfg *TableConfig, object interface{}) (string, []interface{}, error) {
+	t := reflect.Indirect(reflect.ValueOf(object))
+	fields, _ := GetTableColumnMap(cfg, t.Type())
+
+	pkField, err := requiredField(cfg, fields, cfg.PK, t.Type())
+	if err != nil {
+		return "", nil, err
+	}
+
+	sql, args := qb.DeleteById(cfg, t.FieldByName(pkField).Interface())
+
+	return sql, args, nil
+}
+
+func (qb *QueryBuilder) DeleteById(cfg *TableConfig, id interface{}) (string, []interface{}) {
+	args := &queryArgs{}
+
+	sql := qb.deleteSQL(cfg) + " WHERE \"" + cfg.PK + "\" = " + args.add(id)
+	if cfg.SoftDelete != "" {
+		sql += " AND \"" + cfg.SoftDelete + "\" IS NULL"
+	}
+
+	return sql, args.values
+}
+
+// Удаление по фильтрам с той же семантикой, что и в SelectBy: записи отбираются подзапросом по первичному ключу.
+// Пустые фильтры - ошибка, удаление всех записей - DeleteAll
+func (qb *QueryBuilder) DeleteBy(cfg *TableConfig, t reflect.Type, filters map[string]interface{}) (string, []interface{}, error) {
+	if len(filters) == 0 {
+		return "", nil, fmt.Errorf("%w: empty filters for delete, use DeleteAll to delete all rows", ErrInvalidFilter)
+	}
+
+	return qb.deleteBy(cfg, t, filters)
+}
+
+// Удаление всех записей таблицы (при soft_delete - ещё не удалённых)
+func (qb *QueryBuilder) DeleteAll(cfg *TableConfig, t reflect.Type) (string, []interface{}, error) {
+	return qb.deleteBy(cfg, t, nil)
+}
+
+func (qb *QueryBuilder) deleteBy(cfg *TableConfig, t reflect.Type, filters map[string]interface{}) (string, []interface{}, error) {
+	args := &queryArgs{}
+	m0 := MAIN_TABLE_ALIAS
+
+	subQb := &QueryBuilder{}
+	joins := &queryJoins{}
+	filtersStr, err := subQb.filtersSQL(cfg, t, filters, nil, args, joins)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sql := qb.deleteSQL(cfg) + " WHERE \"" + cfg.PK + "\" IN (SELECT \"" + m0 + "\".\"" + cfg.PK + "\" FROM \"" + cfg.TableName +
+		"\" AS \"" + m0 + "\" " + joins.String() + " " + filtersStr + ")"
+
+	return sql, args.values, nil
+}
+
+// При soft_delete удаление превращается в проставление отметки времени удаления
+func (qb *QueryBuilder) deleteSQL(cfg *TableConfig) string {
+	if cfg.SoftDelete != "" {
+		return "UPDATE \"" + cfg.TableName + "\" SET \"" + cfg.SoftDelete + "\" = now()"
+	}
+
+	return "DELETE FROM \"" + cfg.TableName + "\""
+}
+
+func (qb *QueryBuilder) excludeDeleted(cfg *TableConfig) bool {
+	return cfg.SoftDelete != "" && !qb.IncludeDeleted
+}
+
+// JOIN'ы связей запроса, по одному на псевдоним связи
+type queryJoins struct {
+	aliases []string
+	joins   map[string]string
+}
+
+func (j *queryJoins) add(alias string, join string) {
+	if j.joins == nil {
+		j.joins = make(map[string]string)
+	}
+
+	if _, ok := j.joins[alias]; ok {
+		return
+	}
+
+	j.aliases = append(j.aliases, alias)
+	j.joins[alias] = join
+}
+
+func (j *queryJoins) String() string {
+	result := []string{}
+	for _, alias := range j.aliases {
+		result = append(result, j.joins[alias])
+	}
+
+	return strings.Join(result, " ")
+}
+
+// Условия WHERE для фильтров SelectBy и условия keyset-пагинации (если задано), JOIN'ы связей добавляются в joins
+func (qb *QueryBuilder) filtersSQL(cfg *TableConfig, t reflect.Type, filters map[string]interface{}, keyset *keysetExpr, args *queryArgs, joins *queryJoins) (string, error) {
+	m0 := MAIN_TABLE_ALIAS
+
+	tableFilters, err := qb.conditionsSQL(cfg, t, filters, args, joins)
+	if err != nil {
+		return "", err
+	}
+
+	if keyset != nil {
+		tableFilters = append(tableFilters, qb.keysetSQL(keyset, args))
+	}
+
+	if qb.excludeDeleted(cfg) {
+		tableFilters = append(tableFilters, "\""+m0+"\".\""+cfg.SoftDelete+"\" IS NULL")
+	}
+
+	if len(tableFilters) == 0 {
+		return "", nil
+	}
+
+	return "WHERE " + strings.Join(tableFilters, " AND "), nil
+}
+
+// Условия по карте фильтров: ключ - поле сущности или "связь.Поле", значение - значение фильтра.
+// Под ключом FILTER_EXPRESSION_KEY - логическое выражение Or/And/Not или вложенная карта фильтров
+func (qb *QueryBuilder) conditionsSQL(cfg *TableConfig, t reflect.Type, filters map[string]interface{}, args *queryArgs, joins *queryJoins) ([]string, error) {
+	var tableFilters []string
+
+	m0 := MAIN_TABLE_ALIAS
+
+	fields, _ := GetTableColumnMap(cfg, t)
+
+	filterFields := []string{}
+	for filterField := range filters {
+		filterFields = append(filterFields, filterField)
+	}
+	sort.Strings(filterFields)
+
+	for _, filterField := range filterFields {
+		filterValue := filters[filterField]
+
+		if filterField == FILTER_EXPRESSION_KEY {
+			if _, ok := filterValue.(map[string]interface{}); !ok && !isLogicalExpression(filterValue) {
+				return nil, fmt.Errorf("%w: filter %s must be Or/And/Not or Filters, got %v", ErrInvalidFilter, FILTER_EXPRESSION_KEY, filterValue)
 			}
+
+			condition, err := qb.logicalSQL(cfg, t, filterValue, args, joins)
+			if err != nil {
+				return nil, err
+			}
+
+			tableFilters = append(tableFilters, condition)
+			continue
+		}
+
+		if isLogicalExpression(filterValue) {
+			return nil, fmt.Errorf("%w: logical expression under filter key %s, use Where or key %s", ErrInvalidFilter, filterField, FILTER_EXPRESSION_KEY)
 		}
-	}
 
-	for filterField, filterValue := range filters {
 		if ind := strings.Index(filterField, "."); ind != -1 {
 			rel := filterField[:ind]
 			fld := filterField[ind+1:]
-			colName := inflect.Underscore(fld)
-			for _, relation := range relations {
-
-				if rel == relation {
-					relCfg := cfg.Relations[relation]
-					if relCfg.Type == "one_to_one" {
-						if fk, ok := relCfg.Params["foreign_key"]; ok {
-							relTargetCfg := CreateTableConfig(cfg.Dir, relCfg.Target)
-
-							colCfg := relTargetCfg.TableColumns[colName]
-
-							tableJoins = append(tableJoins, fmt.Sprintf("JOIN \"%s\" as \"%s\" ON \"%s\".\"%s\" = \"%s\".\"%s\"",
-								relTargetCfg.TableName, rel, rel, relTargetCfg.PK, m0, fk))
-
-							filtersNotEmpty = true
-							if reflect.TypeOf(filterValue).Kind() == reflect.Array {
-								arr := reflect.ValueOf(&filterValue).Elem()
-								if reflect.ValueOf(filterValue).Len() == 2 {
-									array := arr.Interface().([2]string)
-
-									tableFilters = append(tableFilters, fmt.Sprintf("\""+rel+"\".\""+colName+"\" BETWEEN %s AND %s",
-										qb.escapeValueForSQL(colCfg.Type, array[0], colCfg.Nullable, colCfg.ZeroToNull),
-										qb.escapeValueForSQL(colCfg.Type, array[1], colCfg.Nullable, colCfg.ZeroToNull),
-									))
-								} else {
-									panic(fmt.Sprint("Некорректный массив в фильтрах: ", filterValue))
-								}
-							} else {
-								tableFilters = append(tableFilters, "\""+rel+"\".\""+colName+"\" = "+qb.escapeValueForSQL(colCfg.Type, filterValue, colCfg.Nullable, colCfg.ZeroToNull))
-							}
-
-							//fmt.Println(tableJoins)
-						}
-					}
+
+			alias, relTargetCfg, err := qb.joinRelation(cfg, t, rel, joins)
+			if err != nil {
+				return nil, err
+			}
+
+			if relTargetCfg == nil {
+				return nil, fmt.Errorf("%w: unknown filter relation %s", ErrInvalidFilter, rel)
+			}
+
+			colName := qb.filterColumn(relTargetCfg, nil, fld)
+			if colName == "" {
+				return nil, fmt.Errorf("%w: unknown filter field %s", ErrInvalidFilter, filterField)
+			}
+
+			condition, err := qb.columnFilterSQL("\""+alias+"\".\""+colName+"\"", relTargetCfg, colName, filterValue, args)
+			if err != nil {
+				return nil, err
+			}
+
+			tableFilters = append(tableFilters, condition)
+			continue
+		}
+
+		colName := qb.filterColumn(cfg, fields, filterField)
+		if colName == "" {
+			return nil, fmt.Errorf("%w: unknown filter field %s", ErrInvalidFilter, filterField)
+		}
+
+		condition, err := qb.columnFilterSQL("\""+m0+"\".\""+colName+"\"", cfg, colName, filterValue, args)
+		if err != nil {
+			return nil, err
+		}
+
+		tableFilters = append(tableFilters, condition)
+	}
+
+	return tableFilters, nil
+}
+
+// Колонка, соответствующая полю фильтра. Если fields не nil, учитываются только колонки, найденные в типе сущности
+func (qb *QueryBuilder) filterColumn(cfg *TableConfig, fields map[string]string, field string) string {
+	for _, colName := range cfg.TableColumnsArr {
+		fieldName := ""
+		if fields != nil {
+			var ok bool
+			if fieldName, ok = fields[colName]; !ok {
+				continue
+			}
+		}
+
+		if inflect.Camelize(colName) == field || colName == field || (fieldName != "" && fieldName == field) {
+			return colName
+		}
+	}
+
+	return ""
+}
+
+// Подключает связь one_to_one/many_to_one по имени связи или её поля и возвращает псевдоним и конфигурацию цели.
+// Если такой связи нет, конфигурация цели - nil
+func (qb *QueryBuilder) joinRelation(cfg *TableConfig, t reflect.Type, rel string, joins *queryJoins) (string, *TableConfig, error) {
+	m0 := MAIN_TABLE_ALIAS
+
+	relations, _ := GetTableRelationMap(cfg, t)
+
+	for _, relName := range cfg.RelationsArr {
+		if relName != rel && relations[relName] != rel {
+			continue
+		}
+
+		relCfg := cfg.Relations[relName]
+		if relCfg.Type != "one_to_one" && relCfg.Type != "many_to_one" {
+			return "", nil, nil
+		}
+
+		fk, ok := relCfg.Params["foreign_key"]
+		if !ok {
+			return "", nil, nil
+		}
+
+		var targetType reflect.Type
+		if field, ok := reflect.Indirect(reflect.New(t)).Type().FieldByName(relations[relName]); ok {
+			targetType = relationTargetType(field.Type)
+		}
+
+		relTargetCfg, err := relationTargetConfig(cfg, relName, targetType)
+		if err != nil {
+			return "", nil, err
+		}
+
+		join := fmt.Sprintf("LEFT JOIN \"%s\" AS \"%s\" ON \"%s\".\"%s\" = \"%s\".\"%s\"",
+			relTargetCfg.TableName, relName, relName, relTargetCfg.PK, m0, fk)
+
+		// Удалённые записи цели не подключаются: фильтры и сортировка по их полям работают как для NULL
+		if qb.excludeDeleted(relTargetCfg) {
+			join += " AND \"" + relName + "\".\"" + relTargetCfg.SoftDelete + "\" IS NULL"
+		}
+
+		joins.add(relName, join)
+
+		return relName, relTargetCfg, nil
+	}
+
+	return "", nil, nil
+}
+
+// Or/And/Not и карты фильтров внутри них
+func (qb *QueryBuilder) logicalSQL(cfg *TableConfig, t reflect.Type, expr interface{}, args *queryArgs, joins *queryJoins) (string, error) {
+	switch e := expr.(type) {
+	case map[string]interface{}:
+		conditions, err := qb.conditionsSQL(cfg, t, e, args, joins)
+		if err != nil {
+			return "", err
+		}
+		return qb.joinConditions(conditions, " AND ")
+	case *AndExpr:
+		items, err := qb.logicalItemsSQL(cfg, t, e.Items, args, joins)
+		if err != nil {
+			return "", err
+		}
+		return qb.joinConditions(items, " AND ")
+	case *OrExpr:
+		items, err := qb.logicalItemsSQL(cfg, t, e.Items, args, joins)
+		if err != nil {
+			return "", err
+		}
+		return qb.joinConditions(items, " OR ")
+	case *NotExpr:
+		item, err := qb.logicalSQL(cfg, t, e.Item, args, joins)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + item + ")", nil
+	}
+
+	return "", fmt.Errorf("%w: unsupported logical expression %v", ErrInvalidFilter, expr)
+}
+
+func (qb *QueryBuilder) logicalItemsSQL(cfg *TableConfig, t reflect.Type, exprs []interface{}, args *queryArgs, joins *queryJoins) ([]string, error) {
+	items := []string{}
+	for _, expr := range exprs {
+		item, err := qb.logicalSQL(cfg, t, expr, args, joins)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Пустое логическое выражение - ошибка: условие TRUE/FALSE вместо него незаметно расширило бы или обнулило выборку
+func (qb *QueryBuilder) joinConditions(conditions []string, sep string) (string, error) {
+	if len(conditions) == 0 {
+		return "", fmt.Errorf("%w: empty logical expression", ErrInvalidFilter)
+	}
+
+	if len(conditions) == 1 {
+		return conditions[0], nil
+	}
+
+	return "(" + strings.Join(conditions, sep) + ")", nil
+}
+
+// Условие фильтра по одной колонке: значение, массив из двух значений (BETWEEN), nil (IS NULL) или ColumnExpression.
+// Операнды выражений GT, IN, ANY и других приводятся к типу колонки
+func (qb *QueryBuilder) columnFilterSQL(column string, cfg *TableConfig, colName string, filterValue interface{}, args *queryArgs) (string, error) {
+	if filterValue == nil {
+		return column + " IS NULL", nil
+	}
+
+	if expr, ok := filterValue.(ColumnExpression); ok {
+		if isJSONBExpression(expr) && cfg.TableColumns[colName].Type == "json" {
+			column += "::jsonb"
+		}
+
+		var operandErr error
+		condition := expr.ColumnSQL(column, func(value interface{}) string {
+			if operand, ok := value.(columnOperand); ok {
+				var err error
+				if value, err = qb.operandValue(cfg, colName, operand); err != nil && operandErr == nil {
+					operandErr = err
 				}
 			}
-			//fld := filterField[ind+1:]
+			return args.add(value)
+		})
 
+		if operandErr != nil {
+			return "", operandErr
 		}
+
+		return condition, nil
 	}
 
-	//fmt.Println("RRR", tableColumns)
+	// Массив байт (UUID [16]byte) - значение, а не границы BETWEEN
+	if t := reflect.TypeOf(filterValue); t.Kind() == reflect.Array && t.Elem().Kind() != reflect.Uint8 {
+		arr := reflect.ValueOf(filterValue)
+		if arr.Len() != 2 {
+			return "", fmt.Errorf("%w: array for column %s must have 2 elements, got %v", ErrInvalidFilter, colName, filterValue)
+		}
+
+		from, err := qb.columnValue(cfg, colName, arr.Index(0).Interface())
+		if err != nil {
+			return "", err
+		}
+
+		to, err := qb.columnValue(cfg, colName, arr.Index(1).Interface())
+		if err != nil {
+			return "", err
+		}
 
-	filtersStr := ""
-	if filtersNotEmpty {
-		filtersStr = "WHERE " + strings.Join(tableFilters, " AND ")
+		return column + " BETWEEN " + args.add(from) + " AND " + args.add(to), nil
 	}
 
-	orderBy := " ORDER BY \"" + m0 + "\".\"" + cfg.PK + "\" ASC "
-	if !asc {
-		orderBy = " ORDER BY \"" + m0 + "\".\"" + cfg.PK + "\" DESC "
+	value, err := qb.columnValue(cfg, colName, filterValue)
+	if err != nil {
+		return "", err
 	}
 
-	sql := "SELECT \"" + strings.Join(tableColumns, "\", \"") + "\" FROM \"" + cfg.TableName + "\" AS \"" +
-		m0 + "\" " + strings.Join(tableJoins, " ") + " " + filtersStr + orderBy + " LIMIT " + strconv.Itoa(limit) + " OFFSET " + strconv.Itoa(offset)
+	return column + " = " + args.add(value), nil
+}
+
+// ORDER BY по списку сортировок; без сортировок - по первичному ключу. JOIN'ы связей добавляются в joins
+func (qb *QueryBuilder) orderBySQL(cfg *TableConfig, t reflect.Type, orderBy []Order, joins *queryJoins) (string, error) {
+	m0 := MAIN_TABLE_ALIAS
+
+	if len(orderBy) == 0 {
+		orderBy = []Order{Asc(cfg.PK)}
+	}
+
+	fields, _ := GetTableColumnMap(cfg, t)
+
+	orders := []string{}
+	for _, order := range orderBy {
+		orderColumn := ""
+
+		if ind := strings.Index(order.Field, "."); ind != -1 {
+			alias, relTargetCfg, err := qb.joinRelation(cfg, t, order.Field[:ind], joins)
+			if err != nil {
+				return "", err
+			}
+
+			if relTargetCfg != nil {
+				if colName := qb.filterColumn(relTargetCfg, nil, order.Field[ind+1:]); colName != "" {
+					orderColumn = "\"" + alias + "\".\"" + colName + "\""
+				}
+			}
+		} else if order.Field == cfg.PK {
+			orderColumn = "\"" + m0 + "\".\"" + cfg.PK + "\""
+		} else if colName := qb.filterColumn(cfg, fields, order.Field); colName != "" {
+			orderColumn = "\"" + m0 + "\".\"" + colName + "\""
+		}
+
+		if orderColumn == "" {
+			return "", fmt.Errorf("%w: unknown order field %s", ErrInvalidFilter, order.Field)
+		}
+
+		direction := " ASC"
+		if order.Desc {
+			direction = " DESC"
+		}
+
+		switch order.Nulls {
+		case NullsFirst:
+			direction += " NULLS FIRST"
+		case NullsLast:
+			direction += " NULLS LAST"
+		}
+
+		orders = append(orders, orderColumn+direction)
+	}
+
+	return " ORDER BY " + strings.Join(orders, ", ") + " ", nil
+}
+
+// Выборка записей связи one_to_many: к колонкам записи добавляется внешний ключ foreignKey на родительскую запись
+func (qb *QueryBuilder) SelectByRelation(cfg *TableConfig, t reflect.Type, foreignKey string, ids []interface{}) (string, []interface{}) {
+	var tableColumns []string
+	args := &queryArgs{}
+
+	m0 := MAIN_TABLE_ALIAS
+
+	fields, _ := GetTableColumnMap(cfg, t)
+	for _, colName := range cfg.TableColumnsArr {
+		if _, ok := fields[colName]; ok {
+			tableColumns = append(tableColumns, m0+"\".\""+colName)
+		}
+	}
+
+	for _, relName := range GetTableRelationKeys(cfg, t) {
+		tableColumns = append(tableColumns, m0+"\".\""+cfg.Relations[relName].Params["foreign_key"].(string))
+	}
+
+	tableColumns = append(tableColumns, m0+"\".\""+foreignKey)
+
+	placeholders := []string{}
+	for _, id := range ids {
+		placeholders = append(placeholders, args.add(id))
+	}
+
+	filtersStr := "WHERE \"" + m0 + "\".\"" + foreignKey + "\" IN (" + strings.Join(placeholders, ", ") + ")"
+	if qb.excludeDeleted(cfg) {
+		filtersStr += " AND \"" + m0 + "\".\"" + cfg.SoftDelete + "\" IS NULL"
+	}
+
+	sql := "SELECT \"" + strings.Join(tableColumns, "\", \"") + "\" FROM \"" + cfg.TableName + "\" AS \"" + m0 +
+		"\" " + filtersStr +
+		" ORDER BY \"" + m0 + "\".\"" + cfg.PK + "\" ASC"
 
-	return sql
+	return sql, args.values
 }
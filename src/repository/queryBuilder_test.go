@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateKeepsForeignKeyOfUnloadedRelation(t *testing.T) {
+	cfg := mustStructConfig(t, iterUser{})
+	qb := &QueryBuilder{}
+
+	sql, args, err := qb.Update(cfg, &iterUser{ID: 1, Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sql != `UPDATE "iter_users" SET "name" = $1 WHERE "id" = $2` || !reflect.DeepEqual(args, []interface{}{"a", int64(1)}) {
+		t.Errorf("unexpected UPDATE without relation: %s %v", sql, args)
+	}
+
+	sql, args, err = qb.Update(cfg, &iterUser{ID: 1, Name: "a", Group: &iterGroup{ID: 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sql != `UPDATE "iter_users" SET "name" = $1, "group_id" = $2 WHERE "id" = $3` ||
+		!reflect.DeepEqual(args, []interface{}{"a", int64(7), int64(1)}) {
+		t.Errorf("unexpected UPDATE with relation: %s %v", sql, args)
+	}
+}
+
+func TestSelectBySQL(t *testing.T) {
+	cfg := mustStructConfig(t, iterUser{})
+	qb := &QueryBuilder{}
+
+	sql, args, err := qb.SelectBy(cfg, reflect.TypeOf(iterUser{}), Filters{"Name": "a'; DROP TABLE iter_users; --", "ID": &IN{Ids: []int64{1, 2}}}, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT "m0_"."id", "m0_"."name", "m0_"."group_id" FROM "iter_users" AS "m0_"  ` +
+		`WHERE "m0_"."id" IN ($1, $2) AND "m0_"."name" = $3 ORDER BY "m0_"."id" ASC `
+	if sql != expected || !reflect.DeepEqual(args, []interface{}{int64(1), int64(2), "a'; DROP TABLE iter_users; --"}) {
+		t.Errorf("unexpected SELECT:\n%s\n%v", sql, args)
+	}
+
+	sql, args = qb.SelectById(cfg, reflect.TypeOf(iterUser{}), int64(3))
+	if sql != `SELECT "id", "name", "group_id" FROM "iter_users" WHERE "id" = $1` || !reflect.DeepEqual(args, []interface{}{int64(3)}) {
+		t.Errorf("unexpected SelectById: %s %v", sql, args)
+	}
+}
+
+func TestInsertSQL(t *testing.T) {
+	sql, args, err := (&QueryBuilder{}).Insert(mustStructConfig(t, iterUser{}), &iterUser{Name: "a", Group: &iterGroup{ID: 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `INSERT INTO "iter_users" ("name", "group_id") VALUES ($1, $2) RETURNING "id"`
+	if sql != expected || !reflect.DeepEqual(args, []interface{}{"a", int64(7)}) {
+		t.Errorf("unexpected INSERT:\n%s\n%v", sql, args)
+	}
+}
+
+func TestUpdateColumnsSQL(t *testing.T) {
+	sql, args, err := (&QueryBuilder{}).UpdateColumns(mustStructConfig(t, exprItem{}), &exprItem{ID: 1, Name: "b", Tags: []string{"x"}}, []string{"tags", "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `UPDATE "expr_items" SET "tags" = $1, "name" = $2 WHERE "id" = $3`
+	if sql != expected || !reflect.DeepEqual(args, []interface{}{`{"x"}`, "b", int64(1)}) {
+		t.Errorf("unexpected UPDATE of columns:\n%s\n%v", sql, args)
+	}
+}
+
+type softDoc struct {
+	ID      int64      `repo:"pk"`
+	Title   string     `repo:""`
+	Deleted *time.Time `repo:"softDelete"`
+}
+
+func TestSoftDeleteSQL(t *testing.T) {
+	cfg := mustStructConfig(t, softDoc{})
+	docType := reflect.TypeOf(softDoc{})
+
+	sql, args, err := (&QueryBuilder{}).SelectBy(cfg, docType, Filters{"Title": "a"}, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT "m0_"."id", "m0_"."title", "m0_"."deleted" FROM "soft_docs" AS "m0_"  ` +
+		`WHERE "m0_"."title" = $1 AND "m0_"."deleted" IS NULL ORDER BY "m0_"."id" ASC `
+	if sql != expected || !reflect.DeepEqual(args, []interface{}{"a"}) {
+		t.Errorf("unexpected SELECT:\n%s\n%v", sql, args)
+	}
+
+	sql, _, err = (&QueryBuilder{IncludeDeleted: true}).SelectBy(cfg, docType, Filters{"Title": "a"}, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sql, "IS NULL") {
+		t.Errorf("IncludeDeleted must not filter deleted rows: %s", sql)
+	}
+
+	sql, args = (&QueryBuilder{}).DeleteById(cfg, int64(5))
+	if sql != `UPDATE "soft_docs" SET "deleted" = now() WHERE "id" = $1 AND "deleted" IS NULL` || !reflect.DeepEqual(args, []interface{}{int64(5)}) {
+		t.Errorf("unexpected soft DeleteById: %s %v", sql, args)
+	}
+
+	sql, args, err = (&QueryBuilder{}).DeleteBy(cfg, docType, Filters{"Title": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `UPDATE "soft_docs" SET "deleted" = now() WHERE "id" IN (SELECT "m0_"."id" FROM "soft_docs" AS "m0_"  ` +
+		`WHERE "m0_"."title" = $1 AND "m0_"."deleted" IS NULL)`
+	if sql != expected || !reflect.DeepEqual(args, []interface{}{"a"}) {
+		t.Errorf("unexpected soft DeleteBy:\n%s\n%v", sql, args)
+	}
+}
+func TestDeleteBySQL(t *testing.T) {
+	cfg := mustStructConfig(t, iterUser{})
+	userType := reflect.TypeOf(iterUser{})
+	qb := &QueryBuilder{}
+
+	sql, args, err := qb.DeleteBy(cfg, userType, Filters{"Group.Title": "g"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `DELETE FROM "iter_users" WHERE "id" IN (SELECT "m0_"."id" FROM "iter_users" AS "m0_" ` +
+		`LEFT JOIN "iter_groups" AS "group" ON "group"."id" = "m0_"."group_id" WHERE "group"."title" = $1)`
+	if sql != expected || !reflect.DeepEqual(args, []interface{}{"g"}) {
+		t.Errorf("unexpected DeleteBy:\n%s\n%v", sql, args)
+	}
+
+	sql, args, err = qb.DeleteAll(cfg, userType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != `DELETE FROM "iter_users" WHERE "id" IN (SELECT "m0_"."id" FROM "iter_users" AS "m0_"  )` || len(args) != 0 {
+		t.Errorf("unexpected DeleteAll: %s %v", sql, args)
+	}
+
+	sql, args, err = qb.Delete(cfg, &iterUser{ID: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql != `DELETE FROM "iter_users" WHERE "id" = $1` || !reflect.DeepEqual(args, []interface{}{int64(4)}) {
+		t.Errorf("unexpected Delete: %s %v", sql, args)
+	}
+}
+
+type softNote struct {
+	ID  int64    `repo:"pk"`
+	Doc *softDoc `repo:"rel=many_to_one,fk=doc_id"`
+}
+
+func TestJoinExcludesDeletedTargets(t *testing.T) {
+	cfg := mustStructConfig(t, softNote{})
+	noteType := reflect.TypeOf(softNote{})
+	filters := Filters{"Doc.Title": "a"}
+
+	sql, _, err := (&QueryBuilder{}).SelectBy(cfg, noteType, filters, 0, 0, []Order{Asc("Doc.Title")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join := `LEFT JOIN "soft_docs" AS "doc" ON "doc"."id" = "m0_"."doc_id" AND "doc"."deleted" IS NULL`
+	if strings.Count(sql, "LEFT JOIN") != 1 || !strings.Contains(sql, join) {
+		t.Errorf("deleted targets are joined: %s", sql)
+	}
+
+	sql, _, err = (&QueryBuilder{IncludeDeleted: true}).SelectBy(cfg, noteType, filters, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sql, "IS NULL") {
+		t.Errorf("IncludeDeleted must join deleted targets: %s", sql)
+	}
+}
+
+func TestOrderBySQL(t *testing.T) {
+	cfg := mustStructConfig(t, iterUser{})
+	userType := reflect.TypeOf(iterUser{})
+
+	sql, args, err := (&QueryBuilder{}).SelectBy(cfg, userType, Filters{"Group.Title": "g"}, 0, 0,
+		[]Order{Desc("Name").NullsLast(), Asc("Group.Title").NullsFirst(), Asc("id")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT "m0_"."id", "m0_"."name", "m0_"."group_id" FROM "iter_users" AS "m0_" ` +
+		`LEFT JOIN "iter_groups" AS "group" ON "group"."id" = "m0_"."group_id" WHERE "group"."title" = $1 ` +
+		`ORDER BY "m0_"."name" DESC NULLS LAST, "group"."title" ASC NULLS FIRST, "m0_"."id" ASC `
+	if sql != expected || !reflect.DeepEqual(args, []interface{}{"g"}) {
+		t.Errorf("unexpected ORDER BY:\n%s\n%v", sql, args)
+	}
+
+	for _, order := range []Order{Asc("Nmae"), Asc("Group.Nmae"), Asc("Owner.Name")} {
+		if _, _, err := (&QueryBuilder{}).SelectBy(cfg, userType, nil, 0, 0, []Order{order}); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%v: expected ErrInvalidFilter, got %v", order, err)
+		}
+	}
+}
+
+func TestCountSQL(t *testing.T) {
+	sql, args, err := (&QueryBuilder{}).Count(mustStructConfig(t, softDoc{}), reflect.TypeOf(softDoc{}), Filters{"ID": In(int64(1), int64(2))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT COUNT(*) FROM "soft_docs" AS "m0_"  WHERE "m0_"."id" IN ($1, $2) AND "m0_"."deleted" IS NULL`
+	if sql != expected || !reflect.DeepEqual(args, []interface{}{int64(1), int64(2)}) {
+		t.Errorf("unexpected COUNT:\n%s\n%v", sql, args)
+	}
+}
+
+type onlyPk struct {
+	ID int64 `repo:"pk"`
+}
+
+func TestUpdateWithoutColumns(t *testing.T) {
+	qb := &QueryBuilder{}
+
+	if _, _, err := qb.Update(mustStructConfig(t, onlyPk{}), &onlyPk{ID: 1}); !errors.Is(err, ErrNothingToUpdate) {
+		t.Errorf("expected ErrNothingToUpdate for entity without columns, got %v", err)
+	}
+
+	if _, _, err := qb.UpdateColumns(mustStructConfig(t, iterGroup{}), &iterGroup{ID: 1}, []string{}); !errors.Is(err, ErrNothingToUpdate) {
+		t.Errorf("expected ErrNothingToUpdate for empty column list, got %v", err)
+	}
+
+	f, db := newFakeDb(t)
+	repo, err := NewTypedRepoFromStruct[onlyPk](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Update(&onlyPk{ID: 1}); err != nil || len(f.queries) != 0 {
+		t.Errorf("update without columns must not query: %v, %q", err, f.queries)
+	}
+}
+
+func TestUpdateVersionSQL(t *testing.T) {
+	cfg := mustStructConfig(t, versionedDoc{})
+	qb := &QueryBuilder{}
+	doc := &versionedDoc{ID: 2, Title: "a", Version: 3}
+
+	sql, args, err := qb.Update(cfg, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `UPDATE "versioned_docs" SET "title" = $1, "version" = "version" + 1 WHERE "id" = $2 AND "version" = $3`
+	if sql != expected || !reflect.DeepEqual(args, []interface{}{"a", int64(2), int64(3)}) {
+		t.Errorf("unexpected UPDATE:\n%s\n%v", sql, args)
+	}
+
+	// Без изменённых колонок увеличивается только версия
+	sql, args, err = qb.UpdateColumns(cfg, doc, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `UPDATE "versioned_docs" SET "version" = "version" + 1 WHERE "id" = $1 AND "version" = $2`
+	if sql != expected || !reflect.DeepEqual(args, []interface{}{int64(2), int64(3)}) {
+		t.Errorf("unexpected UPDATE of no columns:\n%s\n%v", sql, args)
+	}
+}
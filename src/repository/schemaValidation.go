@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Результат ValidateConfig: все найденные несоответствия конфигурации таблицы, типа сущности и схемы БД
+type ConfigReport struct {
+	Table    string
+	Problems []string
+}
+
+func (r *ConfigReport) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// Отчёт в виде *ConfigError; nil, если проблем нет
+func (r *ConfigReport) Err() error {
+	if r.Valid() {
+		return nil
+	}
+
+	return &ConfigError{Table: r.Table, Err: errors.New(strings.Join(r.Problems, "; "))}
+}
+
+func (r *ConfigReport) add(problem string) {
+	r.Problems = append(r.Problems, problem)
+}
+
+// Совместимые типы колонок Postgres (udt_name) для типов колонок конфигурации
+var schemaColumnTypes = map[string][]string{
+	"string":      {"text", "varchar", "bpchar", "citext", "name"},
+	"int":         {"int2", "int4", "int8"},
+	"int2":        {"int2"},
+	"int4":        {"int2", "int4"},
+	"int8":        {"int2", "int4", "int8"},
+	"float4":      {"float4"},
+	"float64":     {"float4", "float8"},
+	"bool":        {"bool"},
+	"timestamp":   {"timestamp", "timestamptz"},
+	"timestamptz": {"timestamptz", "timestamp"},
+	"date":        {"date"},
+	"numeric":     {"numeric"},
+	"uuid":        {"uuid"},
+	"json":        {"json", "jsonb"},
+	"jsonb":       {"jsonb", "json"},
+	"bytea":       {"bytea"},
+}
+
+type schemaColumn struct {
+	dataType string
+	udtName  string
+	nullable bool
+}
+
+// Проверяет конфигурацию таблицы по схеме БД (information_schema текущей схемы): существование таблицы и колонок,
+// совместимость типов, соответствие Nullable, таблицы целей связей и колонки внешних ключей.
+// Если передан тип сущности t, проверяются и поля, сопоставленные колонкам и связям (см. GetTableColumnMap).
+// Проблемы конфигурации собираются в отчёт; ошибка возвращается только при сбое запросов к БД
+func ValidateConfig(ctx context.Context, db Executor, cfg *TableConfig, t reflect.Type) (*ConfigReport, error) {
+	report := &ConfigReport{Table: cfg.TableName}
+
+	if t != nil {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		for _, problem := range tableConfigProblems(cfg, t) {
+			report.add(problem)
+		}
+	}
+
+	schemas := make(map[string]map[string]schemaColumn)
+	loadSchema := func(tableName string) (map[string]schemaColumn, error) {
+		if columns, ok := schemas[tableName]; ok {
+			return columns, nil
+		}
+
+		columns, err := tableSchema(ctx, db, tableName)
+		if err != nil {
+			return nil, err
+		}
+		schemas[tableName] = columns
+
+		return columns, nil
+	}
+
+	columns, err := loadSchema(cfg.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(columns) == 0 {
+		report.add("table " + cfg.TableName + " does not exist")
+	} else {
+		for _, colName := range cfg.TableColumnsArr {
+			for _, problem := range columnSchemaProblems(colName, cfg.TableColumns[colName], columns) {
+				report.add(problem)
+			}
+		}
+	}
+
+	var relations map[string]string
+	if t != nil {
+		relations, _ = GetTableRelationMap(cfg, t)
+	}
+
+	for _, relName := range cfg.RelationsArr {
+		relCfg := cfg.Relations[relName]
+
+		var targetType reflect.Type
+		if fieldName, ok := relations[relName]; ok {
+			field, _ := t.FieldByName(fieldName)
+			targetType = relationTargetType(field.Type)
+		}
+
+		targetCfg, err := relationTargetConfig(cfg, relName, targetType)
+		if err != nil {
+			report.add("relation " + relName + ": " + err.Error())
+			continue
+		}
+
+		targetColumns, err := loadSchema(targetCfg.TableName)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(targetColumns) == 0 {
+			report.add("relation " + relName + ": target table " + targetCfg.TableName + " does not exist")
+			continue
+		}
+
+		if _, ok := targetColumns[targetCfg.PK]; !ok {
+			report.add("relation " + relName + ": pk column " + targetCfg.PK + " does not exist in table " + targetCfg.TableName)
+		}
+
+		fk, ok := relCfg.Params["foreign_key"].(string)
+		if !ok {
+			continue
+		}
+
+		// Внешний ключ связи один-ко-многим находится в таблице цели, остальных - в таблице конфигурации
+		fkTable, fkColumns := cfg.TableName, columns
+		if relCfg.Type == "one_to_many" {
+			fkTable, fkColumns = targetCfg.TableName, targetColumns
+		}
+
+		if len(fkColumns) == 0 {
+			continue
+		}
+
+		if _, ok := fkColumns[fk]; !ok {
+			report.add("relation " + relName + ": foreign key column " + fk + " does not exist in table " + fkTable)
+		}
+	}
+
+	return report, nil
+}
+
+// Колонки таблицы tableName текущей схемы; пустая карта, если таблицы нет
+func tableSchema(ctx context.Context, db Executor, tableName string) (map[string]schemaColumn, error) {
+	rows, err := db.QueryContext(ctx, "SELECT column_name, data_type, udt_name, is_nullable FROM information_schema.columns "+
+		"WHERE table_schema = current_schema() AND table_name = $1", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]schemaColumn)
+	for rows.Next() {
+		var name, isNullable string
+		column := schemaColumn{}
+		if err := rows.Scan(&name, &column.dataType, &column.udtName, &isNullable); err != nil {
+			return nil, err
+		}
+
+		column.nullable = isNullable == "YES"
+		columns[name] = column
+	}
+
+	return columns, rows.Err()
+}
+
+func columnSchemaProblems(colName string, colCfg *TableColumnConfig, columns map[string]schemaColumn) []string {
+	column, ok := columns[colName]
+	if !ok {
+		return []string{"column " + colName + " does not exist"}
+	}
+
+	problems := []string{}
+
+	if !schemaTypeCompatible(colCfg.Type, column) {
+		problems = append(problems, "column "+colName+" of type "+colCfg.Type+" does not match database type "+column.udtName)
+	}
+
+	if colCfg.Nullable && !column.nullable {
+		problems = append(problems, "column "+colName+" is nullable in config, but NOT NULL in database")
+	}
+
+	if !colCfg.Nullable && column.nullable {
+		problems = append(problems, "column "+colName+" is not nullable in config, but nullable in database")
+	}
+
+	return problems
+}
+
+func schemaTypeCompatible(typeStr string, column schemaColumn) bool {
+	udtName := column.udtName
+
+	if elemType, ok := arrayElementType(typeStr); ok {
+		if column.dataType != "ARRAY" {
+			return false
+		}
+
+		typeStr = elemType
+		udtName = strings.TrimPrefix(udtName, "_")
+	} else if column.dataType == "ARRAY" {
+		return false
+	}
+
+	// Перечисления и другие пользовательские типы читаются и записываются как строки
+	if typeStr == "string" && column.dataType == "USER-DEFINED" {
+		return true
+	}
+
+	for _, compatible := range schemaColumnTypes[typeStr] {
+		if udtName == compatible {
+			return true
+		}
+	}
+
+	return false
+}
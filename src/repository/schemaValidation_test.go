@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type valAuthor struct {
+	ID   int64  `repo:"pk"`
+	Name string `repo:""`
+}
+
+type valPost struct {
+	ID     int64      `repo:"pk"`
+	Title  string     `repo:""`
+	Score  *int32     `repo:""`
+	Tags   []string   `repo:""`
+	Status string     `repo:""`
+	Author *valAuthor `repo:"rel=many_to_one,fk=author_id"`
+}
+
+var schemaColumns = []string{"column_name", "data_type", "udt_name", "is_nullable"}
+
+func TestValidateConfig(t *testing.T) {
+	f, db := newFakeDb(t)
+	cfg := mustStructConfig(t, valPost{})
+
+	f.addRows(schemaColumns,
+		[]driver.Value{"id", "bigint", "int8", "NO"},
+		[]driver.Value{"title", "text", "text", "YES"},
+		[]driver.Value{"score", "text", "text", "YES"},
+		[]driver.Value{"tags", "ARRAY", "_text", "NO"},
+		[]driver.Value{"status", "USER-DEFINED", "post_status", "NO"},
+		[]driver.Value{"author_id", "bigint", "int8", "YES"})
+	f.addRows(schemaColumns,
+		[]driver.Value{"id", "bigint", "int8", "NO"},
+		[]driver.Value{"name", "character varying", "varchar", "NO"})
+
+	report, err := ValidateConfig(context.Background(), db, cfg, reflect.TypeOf(valPost{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"column title is not nullable in config, but nullable in database",
+		"column score of type int4 does not match database type text",
+	}
+	if !reflect.DeepEqual(report.Problems, expected) {
+		t.Errorf("unexpected problems %q", report.Problems)
+	}
+
+	if !reflect.DeepEqual(f.args, [][]interface{}{{"val_posts"}, {"val_authors"}}) {
+		t.Errorf("expected one schema query per table, got %v", f.args)
+	}
+
+	var configError *ConfigError
+	if report.Valid() || !errors.As(report.Err(), &configError) || configError.Table != "val_posts" {
+		t.Errorf("expected ConfigError from invalid report, got %v", report.Err())
+	}
+}
+
+func TestValidateConfigMissingTables(t *testing.T) {
+	f, db := newFakeDb(t)
+	cfg := mustStructConfig(t, valPost{})
+
+	f.addRows(schemaColumns)
+	f.addRows(schemaColumns)
+
+	report, err := ValidateConfig(context.Background(), db, cfg, reflect.TypeOf(valPost{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"table val_posts does not exist", "relation author: target table val_authors does not exist"}
+	if !reflect.DeepEqual(report.Problems, expected) {
+		t.Errorf("unexpected problems %q", report.Problems)
+	}
+
+	f.addRows(schemaColumns,
+		[]driver.Value{"id", "bigint", "int8", "NO"},
+		[]driver.Value{"title", "text", "text", "NO"},
+		[]driver.Value{"score", "integer", "int4", "YES"},
+		[]driver.Value{"tags", "ARRAY", "_int4", "NO"},
+		[]driver.Value{"status", "text", "text", "NO"})
+	f.addRows(schemaColumns, []driver.Value{"name", "text", "text", "NO"})
+
+	report, err = ValidateConfig(context.Background(), db, cfg, reflect.TypeOf(valPost{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []string{
+		"column tags of type string[] does not match database type _int4",
+		"relation author: pk column id does not exist in table val_authors",
+		"relation author: foreign key column author_id does not exist in table val_posts",
+	}
+	if !reflect.DeepEqual(report.Problems, expected) {
+		t.Errorf("unexpected problems %q", report.Problems)
+	}
+}
+
+func TestValidateConfigValid(t *testing.T) {
+	f, db := newFakeDb(t)
+	cfg := mustStructConfig(t, valAuthor{})
+
+	f.addRows(schemaColumns, []driver.Value{"id", "integer", "int4", "NO"}, []driver.Value{"name", "text", "text", "NO"})
+
+	report, err := ValidateConfig(context.Background(), db, cfg, reflect.TypeOf(&valAuthor{}))
+	if err != nil || !report.Valid() || report.Err() != nil {
+		t.Errorf("expected valid report, got %v, %v", report, err)
+	}
+
+	failed := errors.New("failed")
+	f.fail = func(query string, args []interface{}) error { return failed }
+	if _, err := ValidateConfig(context.Background(), db, cfg, nil); !errors.Is(err, failed) {
+		t.Errorf("expected query error, got %v", err)
+	}
+}
@@ -0,0 +1,427 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+var columnTypes = map[string]bool{
+	"string": true, "int": true, "int2": true, "int4": true, "int8": true, "float4": true, "float64": true, "bool": true,
+	"timestamp": true, "timestamptz": true, "date": true, "numeric": true, "uuid": true, "bytea": true, "json": true, "jsonb": true,
+}
+
+var relationTypes = map[string]bool{"one_to_one": true, "many_to_one": true, "one_to_many": true}
+
+// Конфигурация таблицы по тегам repo полей структуры:
+//
+//	ID       int64   `repo:"pk"`
+//	UserName string  `repo:"column=user_name,type=string,nullable,zeroToNull"`
+//	Version  int32   `repo:"version"`
+//	User     *User   `repo:"rel=many_to_one,target=users,fk=user_id"`
+//	Posts    []*Post `repo:"rel=one_to_many,fk=user_id"`
+//
+// Колонка по умолчанию - имя поля в snake_case, тип выводится из типа поля, поле-указатель - nullable.
+// Также поддерживаются флаги softDelete и cascade_persist (для связей) и имя связи name=. Поля без тега не отображаются.
+// Имя таблицы - TableName() типа или имя типа во множественном числе в snake_case
+func TableConfigFromStruct(t reflect.Type) (*TableConfig, error) {
+	return CreateTableConfigForStruct("", t)
+}
+
+// Конфигурация по тегам структуры, дополненная yaml-файлом dir/<имя таблицы>.yaml, если он есть; при пустом dir - только по тегам.
+// Значения из yaml имеют приоритет: колонки и связи из файла заменяют одноимённые из тегов, остальные добавляются.
+// Конфигурация кэшируется по типу, она общая и не должна изменяться
+func CreateTableConfigForStruct(dir string, t reflect.Type) (*TableConfig, error) {
+	if dir == "" {
+		return structRegistry.ForStruct(t)
+	}
+
+	return dirRegistry(dir).ForStruct(t)
+}
+
+// Реестр без файлов для конфигураций только по тегам
+var structRegistry = NewConfigRegistryFS(emptyFS{})
+
+type emptyFS struct{}
+
+func (emptyFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func structTableConfig(t reflect.Type) (*TableConfig, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	tableName := tableNameOfType(t)
+	configError := func(err error) (*TableConfig, error) {
+		return nil, &ConfigError{Table: tableName, Err: err}
+	}
+
+	if t.Kind() != reflect.Struct {
+		return configError(errors.New("type " + t.String() + " is not a struct"))
+	}
+
+	cfg := NewTableConfig(tableName, "", "")
+	cfg.fromStruct = true
+
+	// Поля встроенных структур учитываются наравне с собственными полями типа
+	for _, field := range reflect.VisibleFields(t) {
+		tag, ok := field.Tag.Lookup("repo")
+		if !ok || tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		params := parseRepoTag(tag)
+
+		if relType, ok := params["rel"]; ok {
+			relName := params["name"]
+			if relName == "" {
+				relName = snakeCase(field.Name)
+			}
+
+			target := params["target"]
+			if target == "" {
+				target = tableNameOfType(relationTargetType(field.Type))
+			}
+
+			c := NewTableRelationConfig(relType, target)
+			c.FieldName = field.Name
+			if fk, ok := params["fk"]; ok {
+				c.Params["foreign_key"] = fk
+			}
+
+			if _, ok := params["cascade_persist"]; ok {
+				c.Params["cascade_persist"] = true
+			}
+
+			cfg.Relations[relName] = c
+			cfg.RelationsArr = append(cfg.RelationsArr, relName)
+			continue
+		}
+
+		colName := params["column"]
+		if colName == "" {
+			colName = snakeCase(field.Name)
+			if field.Name == "ID" || field.Name == "Id" {
+				colName = "id"
+			}
+		}
+
+		typeStr := params["type"]
+		if typeStr == "" {
+			if typeStr, ok = columnTypeOf(field.Type); !ok {
+				return configError(fmt.Errorf("can not infer column type of field %s, set type= in tag", field.Name))
+			}
+		}
+
+		_, nullable := params["nullable"]
+		_, zeroToNull := params["zeroToNull"]
+
+		c := NewTableColumnConfig(nullable || field.Type.Kind() == reflect.Ptr, typeStr)
+		c.ZeroToNull = zeroToNull
+		if colName != "id" {
+			c.FieldName = field.Name
+		}
+
+		if _, ok := params["pk"]; ok {
+			cfg.PK = colName
+		}
+
+		if _, ok := params["version"]; ok {
+			cfg.Version = colName
+		}
+
+		if _, ok := params["softDelete"]; ok {
+			cfg.SoftDelete = colName
+		}
+
+		cfg.TableColumns[colName] = c
+		cfg.TableColumnsArr = append(cfg.TableColumnsArr, colName)
+	}
+
+	if _, ok := cfg.TableColumns["id"]; ok && cfg.PK == "" {
+		cfg.PK = "id"
+	}
+
+	return cfg, nil
+}
+
+// Параметры тега: key=value или флаг (пустое значение)
+func parseRepoTag(tag string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if i := strings.Index(part, "="); i != -1 {
+			params[strings.TrimSpace(part[:i])] = strings.TrimSpace(part[i+1:])
+		} else {
+			params[part] = ""
+		}
+	}
+
+	return params
+}
+
+// Имя в snake_case; аббревиатуры остаются одним словом: URLAuthor - url_author, UserID - user_id
+func snakeCase(name string) string {
+	runes := []rune(name)
+
+	var result strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			acronymEnd := i > 0 && unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || acronymEnd {
+				result.WriteByte('_')
+			}
+		}
+
+		result.WriteRune(unicode.ToLower(r))
+	}
+
+	return result.String()
+}
+
+// Тип колонки по типу поля
+func columnTypeOf(t reflect.Type) (string, bool) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return "timestamptz", true
+	case decimalType:
+		return "numeric", true
+	case uuidType:
+		return "uuid", true
+	case reflect.TypeOf(json.RawMessage{}):
+		return "jsonb", true
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string", true
+	case reflect.Bool:
+		return "bool", true
+	case reflect.Int8, reflect.Int16, reflect.Uint8:
+		return "int2", true
+	case reflect.Int32, reflect.Uint16:
+		return "int4", true
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "int8", true
+	case reflect.Float32:
+		return "float4", true
+	case reflect.Float64:
+		return "float64", true
+	case reflect.Map, reflect.Struct:
+		return "jsonb", true
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytea", true
+		}
+
+		if elemType, ok := columnTypeOf(t.Elem()); ok && columnTypes[elemType] && elemType != "jsonb" && elemType != "bytea" {
+			return elemType + "[]", true
+		}
+	}
+
+	return "", false
+}
+
+// Тип сущности связи: поле может быть структурой, указателем или срезом
+func relationTargetType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// Конфигурация override поверх base; base не изменяется
+func mergeTableConfig(base *TableConfig, override *TableConfig) *TableConfig {
+	result := NewTableConfig(base.TableName, base.PK, override.Dir)
+	result.SoftDelete = base.SoftDelete
+	result.Version = base.Version
+	result.fromStruct = base.fromStruct || override.fromStruct
+	result.registry = base.registry
+	if result.registry == nil {
+		result.registry = override.registry
+	}
+
+	if override.TableName != "" {
+		result.TableName = override.TableName
+	}
+	if override.PK != "" {
+		result.PK = override.PK
+	}
+	if override.SoftDelete != "" {
+		result.SoftDelete = override.SoftDelete
+	}
+	if override.Version != "" {
+		result.Version = override.Version
+	}
+
+	for _, c := range []*TableConfig{base, override} {
+		for constraintName, field := range c.Constraints {
+			result.Constraints[constraintName] = field
+		}
+
+		for _, colName := range c.TableColumnsArr {
+			colCfg := *c.TableColumns[colName]
+			if old, ok := result.TableColumns[colName]; ok {
+				if colCfg.FieldName == "" {
+					colCfg.FieldName = old.FieldName
+				}
+			} else {
+				result.TableColumnsArr = append(result.TableColumnsArr, colName)
+			}
+			result.TableColumns[colName] = &colCfg
+		}
+
+		for _, relName := range c.RelationsArr {
+			relCfg := *c.Relations[relName]
+			relCfg.Params = make(map[string]interface{})
+			for key, value := range c.Relations[relName].Params {
+				relCfg.Params[key] = value
+			}
+
+			if old, ok := result.Relations[relName]; ok {
+				if relCfg.FieldName == "" {
+					relCfg.FieldName = old.FieldName
+				}
+			} else {
+				result.RelationsArr = append(result.RelationsArr, relName)
+			}
+			result.Relations[relName] = &relCfg
+		}
+	}
+
+	return result
+}
+
+// Проверка согласованности конфигурации и типа сущности; все найденные проблемы возвращаются одной *ConfigError
+func validateTableConfig(cfg *TableConfig, t reflect.Type) error {
+	problems := tableConfigProblems(cfg, t)
+	if len(problems) > 0 {
+		return &ConfigError{Table: cfg.TableName, Dir: cfg.Dir, Err: errors.New(strings.Join(problems, "; "))}
+	}
+
+	return nil
+}
+
+// Все несоответствия конфигурации и типа сущности t
+func tableConfigProblems(cfg *TableConfig, t reflect.Type) []string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	problems := []string{}
+
+	if cfg.TableName == "" {
+		problems = append(problems, "table name is not set")
+	}
+
+	if cfg.PK == "" {
+		problems = append(problems, "pk is not set")
+	} else if _, ok := cfg.TableColumns[cfg.PK]; !ok {
+		problems = append(problems, "pk column "+cfg.PK+" is not configured")
+	}
+
+	_, notFound := GetTableColumnMap(cfg, t)
+	for _, colName := range notFound {
+		problems = append(problems, "column "+colName+" has no field in type "+t.Name())
+	}
+
+	for _, colName := range cfg.TableColumnsArr {
+		colCfg := cfg.TableColumns[colName]
+		typeStr, _ := arrayElementType(colCfg.Type)
+		if typeStr == "" {
+			typeStr = colCfg.Type
+		}
+
+		if !columnTypes[typeStr] {
+			problems = append(problems, "column "+colName+" has unknown type "+colCfg.Type)
+		}
+
+		if colCfg.ZeroToNull && !colCfg.Nullable {
+			problems = append(problems, "column "+colName+" has zeroToNull, but is not nullable")
+		}
+	}
+
+	if cfg.Version != "" {
+		if colCfg, ok := cfg.TableColumns[cfg.Version]; !ok {
+			problems = append(problems, "version column "+cfg.Version+" is not configured")
+		} else if !strings.HasPrefix(colCfg.Type, "int") {
+			problems = append(problems, "version column "+cfg.Version+" must have an integer type")
+		}
+	}
+
+	if cfg.SoftDelete != "" {
+		if colCfg, ok := cfg.TableColumns[cfg.SoftDelete]; !ok {
+			problems = append(problems, "soft delete column "+cfg.SoftDelete+" is not configured")
+		} else if !colCfg.Nullable {
+			problems = append(problems, "soft delete column "+cfg.SoftDelete+" must be nullable")
+		}
+	}
+
+	relations, _ := GetTableRelationMap(cfg, t)
+	for _, relName := range cfg.RelationsArr {
+		relCfg := cfg.Relations[relName]
+		if !relationTypes[relCfg.Type] {
+			problems = append(problems, "relation "+relName+" has unknown type "+relCfg.Type)
+		}
+
+		if relCfg.Target == "" {
+			problems = append(problems, "relation "+relName+" has no target")
+		}
+
+		if _, ok := relCfg.Params["foreign_key"]; !ok {
+			problems = append(problems, "relation "+relName+" has no foreign key")
+		}
+
+		fieldName, ok := relations[relName]
+		if !ok {
+			problems = append(problems, "relation "+relName+" has no field in type "+t.Name())
+			continue
+		}
+
+		field, _ := t.FieldByName(fieldName)
+		if relCfg.Type == "one_to_many" && field.Type.Kind() != reflect.Slice {
+			problems = append(problems, "field "+fieldName+" for relation "+relName+" must be a slice")
+		}
+	}
+
+	return problems
+}
+
+// Конфигурация цели связи relName из реестра конфигурации: для конфигураций из тегов - по типу сущности цели
+// для таблицы цели связи (target= или таблица типа), иначе из yaml
+func relationTargetConfig(cfg *TableConfig, relName string, targetType reflect.Type) (*TableConfig, error) {
+	registry := cfg.registry
+	if registry == nil {
+		registry = dirRegistry(cfg.Dir)
+	}
+
+	if cfg.fromStruct {
+		if targetType == nil {
+			return nil, &ConfigError{Table: cfg.TableName, Dir: cfg.Dir, Err: errors.New("relation " + relName + " has no field")}
+		}
+
+		return registry.forStructTable(targetType, cfg.Relations[relName].Target)
+	}
+
+	return registry.Get(cfg.Relations[relName].Target)
+}
@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type tagAuthor struct {
+	ID   int64  `repo:"pk"`
+	Name string `repo:""`
+}
+
+type tagPost struct {
+	ID        int64      `repo:"pk"`
+	Title     string     `repo:"column=headline"`
+	Body      *string    `repo:""`
+	Rating    float64    `repo:""`
+	Tags      []string   `repo:""`
+	Deleted   *int64     `repo:"column=deleted_at,type=timestamptz,softDelete"`
+	Version   int32      `repo:"version"`
+	Writer    *tagAuthor `repo:"rel=many_to_one,name=author,fk=author_id"`
+	URLAuthor *tagAuthor `repo:"rel=many_to_one,fk=url_author_id"`
+	Ignored   string
+	Editors   []*tagAuthor `repo:"rel=one_to_many,target=tag_authors,fk=post_id,cascade_persist"`
+}
+
+func TestTableConfigFromStruct(t *testing.T) {
+	cfg, err := TableConfigFromStruct(reflect.TypeOf(tagPost{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.TableName != "tag_posts" || cfg.PK != "id" || cfg.Version != "version" || cfg.SoftDelete != "deleted_at" {
+		t.Errorf("unexpected table settings: %+v", cfg)
+	}
+
+	expected := []string{"id", "headline", "body", "rating", "tags", "deleted_at", "version"}
+	if !reflect.DeepEqual(cfg.TableColumnsArr, expected) {
+		t.Errorf("columns = %v, expected %v", cfg.TableColumnsArr, expected)
+	}
+
+	types := map[string]string{}
+	for colName, colCfg := range cfg.TableColumns {
+		types[colName] = colCfg.Type
+	}
+	expectedTypes := map[string]string{"id": "int8", "headline": "string", "body": "string", "rating": "float64",
+		"tags": "string[]", "deleted_at": "timestamptz", "version": "int4"}
+	if !reflect.DeepEqual(types, expectedTypes) {
+		t.Errorf("column types = %v, expected %v", types, expectedTypes)
+	}
+
+	if !cfg.TableColumns["body"].Nullable || cfg.TableColumns["headline"].Nullable {
+		t.Errorf("pointer fields must be nullable, others not")
+	}
+
+	if cfg.TableColumns["headline"].FieldName != "Title" {
+		t.Errorf("field name of headline = %q", cfg.TableColumns["headline"].FieldName)
+	}
+
+	if !reflect.DeepEqual(cfg.RelationsArr, []string{"author", "url_author", "editors"}) {
+		t.Fatalf("relations = %v", cfg.RelationsArr)
+	}
+
+	author := cfg.Relations["author"]
+	if author.Type != "many_to_one" || author.Target != "tag_authors" || author.Params["foreign_key"] != "author_id" {
+		t.Errorf("unexpected relation author: %+v", author)
+	}
+
+	if cfg.Relations["editors"].Params["cascade_persist"] != true {
+		t.Errorf("cascade_persist not set for editors")
+	}
+
+	relations, notFound := GetTableRelationMap(cfg, reflect.TypeOf(tagPost{}))
+	if len(notFound) != 0 || relations["author"] != "Writer" || relations["url_author"] != "URLAuthor" {
+		t.Errorf("relation fields = %v, not found %v", relations, notFound)
+	}
+}
+
+func TestRelationFilterByName(t *testing.T) {
+	cfg, err := TableConfigFromStruct(reflect.TypeOf(tagPost{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qb := &QueryBuilder{}
+	for _, key := range []string{"author.Name", "Writer.Name"} {
+		sql, args, err := qb.SelectBy(cfg, reflect.TypeOf(tagPost{}), Filters{key: "a"}, 0, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(sql, `LEFT JOIN "tag_authors" AS "author" ON "author"."id" = "m0_"."author_id"`) ||
+			!strings.Contains(sql, `"author"."name" = $1`) || !reflect.DeepEqual(args, []interface{}{"a"}) {
+			t.Errorf("unexpected query for %s: %s %v", key, sql, args)
+		}
+	}
+}
+
+func TestTableConfigFromStructErrors(t *testing.T) {
+	type noPk struct {
+		Name string `repo:""`
+	}
+
+	type badType struct {
+		ID    int64    `repo:"pk"`
+		Thing chan int `repo:""`
+	}
+
+	type badVersion struct {
+		ID      int64  `repo:"pk"`
+		Version string `repo:"version"`
+	}
+
+	for _, entity := range []interface{}{noPk{}, badType{}, badVersion{}} {
+		_, err := TableConfigFromStruct(reflect.TypeOf(entity))
+
+		var configError *ConfigError
+		if !errors.As(err, &configError) {
+			t.Errorf("expected ConfigError for %T, got %v", entity, err)
+		}
+	}
+}
+
+func TestStructConfigYamlOverride(t *testing.T) {
+	registry := NewConfigRegistryFS(fstest.MapFS{
+		"tag_authors.yaml": &fstest.MapFile{Data: []byte(`
+columns:
+  - name: {nullable: true, type: string, fieldName: Name}
+constraints:
+  tag_authors_name_key: name
+`)},
+	})
+
+	cfg, err := registry.ForStruct(reflect.TypeOf(tagAuthor{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cfg.TableColumns["name"].Nullable || cfg.Constraints["tag_authors_name_key"] != "name" {
+		t.Errorf("yaml override not applied: %+v", cfg.TableColumns["name"])
+	}
+
+	cached, err := registry.ForStruct(reflect.TypeOf(&tagAuthor{}))
+	if err != nil || cached != cfg {
+		t.Errorf("expected cached config, got %p %v", cached, err)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Name": "name", "UserName": "user_name", "UserID": "user_id", "URLAuthor": "url_author",
+		"ID": "id", "HTTPLog": "http_log", "Address2": "address2",
+	} {
+		if result := snakeCase(name); result != expected {
+			t.Errorf("snakeCase(%s) = %s, expected %s", name, result, expected)
+		}
+	}
+}
+
+type tgtAccount struct {
+	ID   int64  `repo:"pk"`
+	Name string `repo:""`
+}
+
+type tgtPost struct {
+	ID     int64       `repo:"pk"`
+	Author *tgtAccount `repo:"rel=many_to_one,target=users,fk=user_id"`
+}
+
+func TestRelationTargetTable(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[tgtPost](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.addRows([]string{"id", "user_id"}, []driver.Value{int64(1), int64(7)})
+	f.addRows([]string{"id", "name"}, []driver.Value{int64(7), "a"})
+
+	post, err := repo.Find(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if post.Author == nil || post.Author.Name != "a" || !strings.Contains(f.queries[1], `FROM "users" AS "m0_"`) {
+		t.Errorf("relation must be loaded from target table: %+v, %q", post.Author, f.queries)
+	}
+
+	sql, _, err := (&QueryBuilder{}).SelectBy(repo.Repo().config, reflect.TypeOf(tgtPost{}), Filters{"Author.Name": "a"}, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sql, `LEFT JOIN "users" AS "author"`) {
+		t.Errorf("join must use target table: %s", sql)
+	}
+
+	// Конфигурация типа для его собственной таблицы не меняется
+	if cfg := mustStructConfig(t, tgtAccount{}); cfg.TableName != "tgt_accounts" {
+		t.Errorf("table of type config changed to %s", cfg.TableName)
+	}
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"database/sql"
+	"reflect"
+)
+
+// Встраиваемая в сущность структура: хранит значения колонок на момент загрузки,
+// чтобы Update без сессии обновлял только изменённые колонки
+type Tracking struct {
+	snapshot map[string]interface{}
+}
+
+type trackable interface {
+	repoSnapshot() map[string]interface{}
+	setRepoSnapshot(snapshot map[string]interface{})
+}
+
+func (t *Tracking) repoSnapshot() map[string]interface{} {
+	return t.snapshot
+}
+
+func (t *Tracking) setRepoSnapshot(snapshot map[string]interface{}) {
+	t.snapshot = snapshot
+}
+
+// Значения колонок сущности на момент загрузки или последнего сохранения: из сессии или из Tracking
+func (a *AbstractRepo) loadedSnapshot(object interface{}) (map[string]interface{}, bool) {
+	if a.session != nil {
+		if snapshot, ok := a.session.snapshots[object]; ok {
+			return snapshot, true
+		}
+	}
+
+	if tr, ok := object.(trackable); ok && tr.repoSnapshot() != nil {
+		return tr.repoSnapshot(), true
+	}
+
+	return nil, false
+}
+
+func (a *AbstractRepo) rememberSnapshot(object interface{}) {
+	_, values, err := a.qb.columnValues(a.config, object)
+	if err != nil {
+		return
+	}
+
+	a.setSnapshot(object, copyColumnValues(values))
+}
+
+func (a *AbstractRepo) setSnapshot(object interface{}, snapshot map[string]interface{}) {
+	if a.session != nil {
+		if _, ok := a.session.snapshots[object]; ok {
+			a.session.snapshots[object] = snapshot
+		}
+	}
+
+	if tr, ok := object.(trackable); ok {
+		tr.setRepoSnapshot(snapshot)
+	}
+}
+
+// Снимок записанных значений запоминается только после фиксации транзакции: при откате или повторе
+// транзакции изменённые колонки должны остаться изменёнными
+func (a *AbstractRepo) rememberWrittenSnapshot(object interface{}) {
+	switch tx := a.db.(type) {
+	case *Tx:
+		_, values, err := a.qb.columnValues(a.config, object)
+		if err != nil {
+			return
+		}
+
+		snapshot := copyColumnValues(values)
+		tx.onCommit(func() {
+			a.setSnapshot(object, snapshot)
+		})
+	case *sql.Tx:
+		// Фиксация внешней транзакции неизвестна, снимок остаётся прежним
+	default:
+		a.rememberSnapshot(object)
+	}
+}
+
+// Колонки для UPDATE: nil, если снимка нет и нужно обновить все колонки
+func (a *AbstractRepo) updateColumns(object interface{}) []string {
+	snapshot, ok := a.loadedSnapshot(object)
+	if !ok {
+		return nil
+	}
+
+	// Ошибку значений колонок вернёт построение UPDATE по всем колонкам
+	columns, values, err := a.qb.columnValues(a.config, object)
+	if err != nil {
+		return nil
+	}
+
+	return changedColumns(columns, snapshot, values)
+}
+
+func changedColumns(columns []string, snapshot map[string]interface{}, values map[string]interface{}) []string {
+	changed := []string{}
+	for _, colName := range columns {
+		old, ok := snapshot[colName]
+		if !ok || !reflect.DeepEqual(old, values[colName]) {
+			changed = append(changed, colName)
+		}
+	}
+
+	return changed
+}
+
+// Копия значений колонок: срезы и карты копируются, чтобы изменения сущности не меняли снимок
+func copyColumnValues(values map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(values))
+	for colName, value := range values {
+		v := reflect.ValueOf(value)
+		switch v.Kind() {
+		case reflect.Slice:
+			if !v.IsNil() {
+				c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+				reflect.Copy(c, v)
+				value = c.Interface()
+			}
+		case reflect.Map:
+			if !v.IsNil() {
+				c := reflect.MakeMapWithSize(v.Type(), v.Len())
+				for _, key := range v.MapKeys() {
+					c.SetMapIndex(key, v.MapIndex(key))
+				}
+				value = c.Interface()
+			}
+		}
+
+		result[colName] = value
+	}
+
+	return result
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type trackedUser struct {
+	Tracking
+	ID   int64  `repo:"pk"`
+	Name string `repo:""`
+}
+
+func newTrackedUserRepo(t *testing.T) (*fakeDb, *TypedRepo[trackedUser]) {
+	f, db := newFakeDb(t)
+
+	repo, err := NewTypedRepoFromStruct[trackedUser](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f, repo
+}
+
+func TestTrackingSnapshotKeptOnRollback(t *testing.T) {
+	f, repo := newTrackedUserRepo(t)
+	ctx := context.Background()
+
+	f.addRows([]string{"id", "name"}, []driver.Value{int64(1), "a"})
+	user, err := repo.FindCtx(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Name = "b"
+	rollback := errors.New("rollback")
+	err = RunInTx(ctx, repo.Repo().db, func(tx *Tx) error {
+		if err := repo.WithTx(tx).UpdateCtx(ctx, user); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("expected rollback error, got %v", err)
+	}
+
+	if err := repo.UpdateCtx(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := f.queriesWithPrefix("UPDATE")
+	if len(updates) != 2 {
+		t.Fatalf("expected UPDATE after rolled back transaction, got %v", f.queries)
+	}
+	if updates[0] != updates[1] {
+		t.Errorf("UPDATE after rollback differs: %s, %s", updates[0], updates[1])
+	}
+
+	if err := repo.UpdateCtx(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.queriesWithPrefix("UPDATE")); n != 2 {
+		t.Errorf("unchanged entity updated again after commit")
+	}
+}
+
+func TestSessionCommitRetryRepeatsUpdate(t *testing.T) {
+	f, repo := newTrackedUserRepo(t)
+	ctx := context.Background()
+
+	session := NewSession(repo.Repo().db)
+	sessionRepo := repo.WithSession(session)
+
+	f.addRows([]string{"id", "name"}, []driver.Value{int64(1), "a"})
+	user, err := sessionRepo.FindCtx(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := false
+	f.fail = func(query string, args []interface{}) error {
+		if query == "COMMIT" && !failed {
+			failed = true
+			return &fakePqError{Code: "40001"}
+		}
+		return nil
+	}
+
+	user.Name = "b"
+	if err := session.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(f.queriesWithPrefix("UPDATE")); n != 2 {
+		t.Fatalf("expected UPDATE in both attempts, got %v", f.queries)
+	}
+
+	dirty, err := session.dirtyColumns(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirty) != 0 {
+		t.Errorf("expected no dirty columns after commit, got %v", dirty)
+	}
+}
+
+func TestChangedColumns(t *testing.T) {
+	snapshot := map[string]interface{}{"id": int64(1), "name": "a", "tags": []string{"x"}}
+	values := map[string]interface{}{"id": int64(1), "name": "b", "tags": []string{"x"}}
+
+	changed := changedColumns([]string{"id", "name", "tags"}, snapshot, values)
+	if !reflect.DeepEqual(changed, []string{"name"}) {
+		t.Errorf("changedColumns = %v", changed)
+	}
+}
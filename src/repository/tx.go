@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+)
+
+// Количество повторов транзакции RunInTx при ошибке сериализации (SQLSTATE 40001)
+const TX_MAX_RETRIES = 3
+
+// Общий интерфейс *sql.DB, *sql.Tx и *Tx для выполнения запросов репозиториями
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Транзакция RunInTx. Вложенные RunInTx с этой транзакцией выполняются в точках сохранения
+type Tx struct {
+	*sql.Tx
+	depth   int
+	actions *txActions
+}
+
+// Действия после фиксации транзакции верхнего уровня и после отката транзакции или точки сохранения
+type txActions struct {
+	commit   []func()
+	rollback []func()
+}
+
+var _ Executor = (*sql.DB)(nil)
+var _ Executor = (*sql.Tx)(nil)
+var _ Executor = (*Tx)(nil)
+
+// Выполняет fn в транзакции: commit, если fn вернула nil, иначе rollback.
+// Если db - *Tx или *sql.Tx, fn выполняется в точке сохранения внутри этой транзакции.
+// Транзакция верхнего уровня повторяется при ошибке сериализации Postgres
+func RunInTx(ctx context.Context, db Executor, fn func(tx *Tx) error) error {
+	switch parent := db.(type) {
+	case *Tx:
+		return runInSavepoint(ctx, &Tx{Tx: parent.Tx, depth: parent.depth + 1, actions: parent.txActions()}, fn)
+	case *sql.Tx:
+		// Фиксацией внешней транзакции управляет вызывающий код, действия после неё не выполняются
+		return runInSavepoint(ctx, &Tx{Tx: parent, depth: 1, actions: &txActions{}}, fn)
+	case txBeginner:
+		var err error
+		for attempt := 0; attempt <= TX_MAX_RETRIES; attempt++ {
+			err = runInTx(ctx, parent, fn)
+			if err == nil || sqlState(err) != "40001" || ctx.Err() != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	return errors.New("Executor does not support transactions")
+}
+
+func runInTx(ctx context.Context, db txBeginner, fn func(tx *Tx) error) (err error) {
+	sqlTx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	tx := &Tx{Tx: sqlTx, actions: &txActions{}}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			tx.actions.rolledBack(0)
+			panic(p)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		tx.actions.rolledBack(0)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		tx.actions.rolledBack(0)
+		return err
+	}
+
+	for _, action := range tx.actions.commit {
+		action()
+	}
+
+	return nil
+}
+
+func runInSavepoint(ctx context.Context, tx *Tx, fn func(tx *Tx) error) (err error) {
+	savepoint := "sp_" + strconv.Itoa(tx.depth)
+
+	if _, err = tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return err
+	}
+
+	commitActions, rollbackActions := len(tx.actions.commit), len(tx.actions.rollback)
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint)
+			tx.actions.commit = tx.actions.commit[:commitActions]
+			tx.actions.rolledBack(rollbackActions)
+			panic(p)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint)
+		tx.actions.commit = tx.actions.commit[:commitActions]
+		tx.actions.rolledBack(rollbackActions)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint)
+
+	return err
+}
+
+// Выполняет в обратном порядке и отбрасывает действия отката, добавленные после первых from
+func (a *txActions) rolledBack(from int) {
+	for i := len(a.rollback) - 1; i >= from; i-- {
+		a.rollback[i]()
+	}
+
+	a.rollback = a.rollback[:from]
+}
+
+func (tx *Tx) txActions() *txActions {
+	if tx.actions == nil {
+		tx.actions = &txActions{}
+	}
+
+	return tx.actions
+}
+
+// Откладывает action до фиксации транзакции; при откате action отбрасывается
+func (tx *Tx) onCommit(action func()) {
+	actions := tx.txActions()
+	actions.commit = append(actions.commit, action)
+}
+
+// Выполняет action при откате транзакции или точки сохранения, в которой он добавлен
+func (tx *Tx) onRollback(action func()) {
+	actions := tx.txActions()
+	actions.rollback = append(actions.rollback, action)
+}
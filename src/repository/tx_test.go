@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunInTxNestedSavepoints(t *testing.T) {
+	f, db := newFakeDb(t)
+	ctx := context.Background()
+	inner := errors.New("inner")
+
+	actions := []string{}
+	err := RunInTx(ctx, db, func(tx *Tx) error {
+		tx.ExecContext(ctx, "INSERT a")
+		tx.onCommit(func() { actions = append(actions, "commit a") })
+
+		return RunInTx(ctx, tx, func(tx *Tx) error {
+			tx.ExecContext(ctx, "INSERT b")
+			tx.onCommit(func() { actions = append(actions, "commit b") })
+
+			err := RunInTx(ctx, tx, func(tx *Tx) error {
+				tx.ExecContext(ctx, "INSERT c")
+				tx.onCommit(func() { actions = append(actions, "commit c") })
+				tx.onRollback(func() { actions = append(actions, "rollback c") })
+				return inner
+			})
+			if !errors.Is(err, inner) {
+				t.Errorf("expected inner error, got %v", err)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"BEGIN", "INSERT a", "SAVEPOINT sp_1", "INSERT b", "SAVEPOINT sp_2", "INSERT c",
+		"ROLLBACK TO SAVEPOINT sp_2", "RELEASE SAVEPOINT sp_1", "COMMIT"}
+	if !reflect.DeepEqual(f.queries, expected) {
+		t.Errorf("unexpected queries %q", f.queries)
+	}
+
+	if !reflect.DeepEqual(actions, []string{"rollback c", "commit a", "commit b"}) {
+		t.Errorf("unexpected actions %q", actions)
+	}
+}
+
+func TestRunInTxRollback(t *testing.T) {
+	f, db := newFakeDb(t)
+	ctx := context.Background()
+	failed := errors.New("failed")
+
+	actions := []string{}
+	err := RunInTx(ctx, db, func(tx *Tx) error {
+		tx.onCommit(func() { actions = append(actions, "commit") })
+		tx.onRollback(func() { actions = append(actions, "rollback 1") })
+
+		return RunInTx(ctx, tx, func(tx *Tx) error {
+			tx.onRollback(func() { actions = append(actions, "rollback 2") })
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0] != "commit" {
+		t.Errorf("rollback actions must not run after commit: %q", actions)
+	}
+
+	actions = nil
+	err = RunInTx(ctx, db, func(tx *Tx) error {
+		tx.onCommit(func() { actions = append(actions, "commit") })
+		tx.onRollback(func() { actions = append(actions, "rollback 1") })
+
+		if err := RunInTx(ctx, tx, func(tx *Tx) error {
+			tx.onRollback(func() { actions = append(actions, "rollback 2") })
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected fn error, got %v", err)
+	}
+
+	if !reflect.DeepEqual(actions, []string{"rollback 2", "rollback 1"}) {
+		t.Errorf("expected rollback actions in reverse order, got %q", actions)
+	}
+	if f.commits != 1 || f.rollback != 1 {
+		t.Errorf("expected 1 commit and 1 rollback, got %d and %d", f.commits, f.rollback)
+	}
+}
+
+func TestRunInTxPanic(t *testing.T) {
+	f, db := newFakeDb(t)
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("expected panic to be repeated, got %v", p)
+		}
+		if f.rollback != 1 || f.commits != 0 {
+			t.Errorf("expected rollback on panic, got %q", f.queries)
+		}
+	}()
+
+	RunInTx(context.Background(), db, func(tx *Tx) error {
+		panic("boom")
+	})
+}
+
+func TestRunInTxSqlTxParent(t *testing.T) {
+	f, db := newFakeDb(t)
+	ctx := context.Background()
+
+	sqlTx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	committed := false
+	if err := RunInTx(ctx, sqlTx, func(tx *Tx) error {
+		tx.onCommit(func() { committed = true })
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	if err := RunInTx(ctx, sqlTx, func(tx *Tx) error { return failed }); !errors.Is(err, failed) {
+		t.Errorf("expected fn error, got %v", err)
+	}
+
+	if err := sqlTx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"BEGIN", "SAVEPOINT sp_1", "RELEASE SAVEPOINT sp_1", "SAVEPOINT sp_1", "ROLLBACK TO SAVEPOINT sp_1", "COMMIT"}
+	if !reflect.DeepEqual(f.queries, expected) {
+		t.Errorf("unexpected queries %q", f.queries)
+	}
+
+	// Фиксацией *sql.Tx управляет вызывающий код, действия после неё не выполняются
+	if committed {
+		t.Errorf("commit actions must not run for *sql.Tx parent")
+	}
+}
+
+func TestRunInTxRetries(t *testing.T) {
+	f, db := newFakeDb(t)
+	ctx := context.Background()
+
+	f.fail = func(query string, args []interface{}) error {
+		if query == "COMMIT" {
+			return &fakePqError{Code: "40001"}
+		}
+		return nil
+	}
+
+	attempts, rollbacks := 0, 0
+	err := RunInTx(ctx, db, func(tx *Tx) error {
+		attempts++
+		tx.onRollback(func() { rollbacks++ })
+		return nil
+	})
+	if sqlState(err) != "40001" {
+		t.Fatalf("expected serialization failure, got %v", err)
+	}
+
+	if attempts != TX_MAX_RETRIES+1 || rollbacks != attempts || len(f.queriesWithPrefix("BEGIN")) != attempts {
+		t.Errorf("expected %d attempts, got %d attempts and %d rollbacks", TX_MAX_RETRIES+1, attempts, rollbacks)
+	}
+
+	f.fail = func(query string, args []interface{}) error {
+		if query == "COMMIT" {
+			return &fakePqError{Code: "23505"}
+		}
+		return nil
+	}
+
+	attempts = 0
+	if err := RunInTx(ctx, db, func(tx *Tx) error {
+		attempts++
+		return nil
+	}); sqlState(err) != "23505" || attempts != 1 {
+		t.Errorf("other errors must not be retried: %v, %d attempts", err, attempts)
+	}
+
+	failed := 0
+	f.fail = func(query string, args []interface{}) error {
+		if query == "COMMIT" && failed < 2 {
+			failed++
+			return &fakePqError{Code: "40001"}
+		}
+		return nil
+	}
+
+	attempts = 0
+	if err := RunInTx(ctx, db, func(tx *Tx) error {
+		attempts++
+		return nil
+	}); err != nil || attempts != 3 {
+		t.Errorf("expected success on third attempt, got %v, %d attempts", err, attempts)
+	}
+}
+
+func TestRunInTxWithoutTransactions(t *testing.T) {
+	_, db := newFakeDb(t)
+
+	executor := struct{ Executor }{db}
+	if err := RunInTx(context.Background(), executor, func(tx *Tx) error { return nil }); err == nil {
+		t.Errorf("expected error for executor without transactions")
+	}
+}
@@ -0,0 +1,249 @@
+package repository
+
+import (
+	"bitbucket.org/pkg/inflect"
+	"context"
+	"reflect"
+)
+
+// Сущность может сама задать имя таблицы (и имя yaml-файла конфигурации)
+type TableNamer interface {
+	TableName() string
+}
+
+// Типизированная обёртка над AbstractRepo: тип сущности и конфигурация выводятся из T
+type TypedRepo[T any] struct {
+	repo *AbstractRepo
+}
+
+func NewTypedRepo[T any](db Executor, dir string) (*TypedRepo[T], error) {
+	config, err := CreateTableConfig(dir, TableNameOf[T]())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTypedRepoWithConfig[T](db, config), nil
+}
+
+// Репозиторий с конфигурацией таблицы T из реестра
+func NewTypedRepoFromRegistry[T any](db Executor, registry *ConfigRegistry) (*TypedRepo[T], error) {
+	config, err := registry.Get(TableNameOf[T]())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTypedRepoWithConfig[T](db, config), nil
+}
+
+// Репозиторий с конфигурацией по тегам repo полей T, дополненной yaml-файлом из dir, если он есть (dir может быть пустым)
+func NewTypedRepoFromStruct[T any](db Executor, dir string) (*TypedRepo[T], error) {
+	config, err := CreateTableConfigForStruct(dir, reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTypedRepoWithConfig[T](db, config), nil
+}
+
+func NewTypedRepoWithConfig[T any](db Executor, config *TableConfig) *TypedRepo[T] {
+	return &TypedRepo[T]{repo: NewAbstractRepo(db, config, reflect.TypeOf((*T)(nil)).Elem())}
+}
+
+// Имя таблицы для T: TableName(), если T его реализует, иначе имя типа во множественном числе в snake_case
+func TableNameOf[T any]() string {
+	return tableNameOfType(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+func tableNameOfType(t reflect.Type) string {
+	entity := reflect.New(t)
+	if namer, ok := entity.Elem().Interface().(TableNamer); ok {
+		return namer.TableName()
+	}
+
+	if namer, ok := entity.Interface().(TableNamer); ok {
+		return namer.TableName()
+	}
+
+	return inflect.Pluralize(snakeCase(t.Name()))
+}
+
+func (r *TypedRepo[T]) Repo() *AbstractRepo {
+	return r.repo
+}
+
+func (r *TypedRepo[T]) WithTx(tx Executor) *TypedRepo[T] {
+	return &TypedRepo[T]{repo: r.repo.WithTx(tx)}
+}
+
+func (r *TypedRepo[T]) WithSession(s *Session) *TypedRepo[T] {
+	return &TypedRepo[T]{repo: r.repo.WithSession(s)}
+}
+
+func (r *TypedRepo[T]) Find(id int64) (*T, error) {
+	return r.FindCtx(context.Background(), id)
+}
+
+func (r *TypedRepo[T]) FindCtx(ctx context.Context, id int64) (*T, error) {
+	object, err := r.repo.FindCtx(ctx, id)
+	if err != nil || object == nil {
+		return nil, err
+	}
+
+	return object.(*T), nil
+}
+
+func (r *TypedRepo[T]) FindOneBy(filters map[string]interface{}, orderBy []Order) (*T, error) {
+	return r.FindOneByCtx(context.Background(), filters, orderBy)
+}
+
+func (r *TypedRepo[T]) FindOneByCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order) (*T, error) {
+	object, err := r.repo.FindOneByCtx(ctx, filters, orderBy)
+	if err != nil || object == nil {
+		return nil, err
+	}
+
+	return object.(*T), nil
+}
+
+func (r *TypedRepo[T]) FindBy(filters map[string]interface{}, orderBy []Order, limit int, offset int) ([]*T, error) {
+	return r.FindByCtx(context.Background(), filters, orderBy, limit, offset)
+}
+
+func (r *TypedRepo[T]) FindByCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order, limit int, offset int) ([]*T, error) {
+	objects, err := r.repo.FindByCtx(ctx, filters, orderBy, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return typedList[T](objects), nil
+}
+
+func (r *TypedRepo[T]) FindAll() ([]*T, error) {
+	return r.FindAllCtx(context.Background())
+}
+
+func (r *TypedRepo[T]) FindAllCtx(ctx context.Context) ([]*T, error) {
+	objects, err := r.repo.FindAllCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return typedList[T](objects), nil
+}
+
+type TypedPageResult[T any] struct {
+	Items  []*T
+	Total  int64
+	Number int
+	Size   int
+}
+
+// Количество страниц при текущем размере страницы
+func (r *TypedPageResult[T]) Pages() int {
+	return pageCount(r.Total, r.Size)
+}
+
+func (r *TypedRepo[T]) FindPage(filters map[string]interface{}, orderBy []Order, page Page) (*TypedPageResult[T], error) {
+	return r.FindPageCtx(context.Background(), filters, orderBy, page)
+}
+
+func (r *TypedRepo[T]) FindPageCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order, page Page) (*TypedPageResult[T], error) {
+	result, err := r.repo.FindPageCtx(ctx, filters, orderBy, page)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedPageResult[T]{Items: typedList[T](result.Items), Total: result.Total, Number: result.Number, Size: result.Size}, nil
+}
+
+func (r *TypedRepo[T]) FindAfter(filters map[string]interface{}, cursor string, limit int, orderBy ...Order) ([]*T, string, error) {
+	return r.FindAfterCtx(context.Background(), filters, cursor, limit, orderBy...)
+}
+
+func (r *TypedRepo[T]) FindAfterCtx(ctx context.Context, filters map[string]interface{}, cursor string, limit int, orderBy ...Order) ([]*T, string, error) {
+	objects, next, err := r.repo.FindAfterCtx(ctx, filters, cursor, limit, orderBy...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return typedList[T](objects), next, nil
+}
+
+func (r *TypedRepo[T]) Iterate(filters map[string]interface{}, orderBy []Order, fn func(entity *T) error) error {
+	return r.IterateCtx(context.Background(), filters, orderBy, fn)
+}
+
+func (r *TypedRepo[T]) IterateCtx(ctx context.Context, filters map[string]interface{}, orderBy []Order, fn func(entity *T) error) error {
+	return r.repo.IterateCtx(ctx, filters, orderBy, func(entity interface{}) error {
+		return fn(entity.(*T))
+	})
+}
+
+func (r *TypedRepo[T]) Count(filters map[string]interface{}) (int64, error) {
+	return r.CountCtx(context.Background(), filters)
+}
+
+func (r *TypedRepo[T]) CountCtx(ctx context.Context, filters map[string]interface{}) (int64, error) {
+	return r.repo.CountCtx(ctx, filters)
+}
+
+func (r *TypedRepo[T]) Save(entity *T) (int64, error) {
+	return r.SaveCtx(context.Background(), entity)
+}
+
+func (r *TypedRepo[T]) SaveCtx(ctx context.Context, entity *T) (int64, error) {
+	return r.repo.SaveCtx(ctx, entity)
+}
+
+func (r *TypedRepo[T]) Update(entity *T) error {
+	return r.UpdateCtx(context.Background(), entity)
+}
+
+func (r *TypedRepo[T]) UpdateCtx(ctx context.Context, entity *T) error {
+	return r.repo.UpdateCtx(ctx, entity)
+}
+
+func (r *TypedRepo[T]) Delete(entity *T) error {
+	return r.DeleteCtx(context.Background(), entity)
+}
+
+func (r *TypedRepo[T]) DeleteCtx(ctx context.Context, entity *T) error {
+	return r.repo.DeleteCtx(ctx, entity)
+}
+
+func (r *TypedRepo[T]) DeleteById(id int64) error {
+	return r.DeleteByIdCtx(context.Background(), id)
+}
+
+func (r *TypedRepo[T]) DeleteByIdCtx(ctx context.Context, id int64) error {
+	return r.repo.DeleteByIdCtx(ctx, id)
+}
+
+func (r *TypedRepo[T]) DeleteBy(filters map[string]interface{}) (int64, error) {
+	return r.DeleteByCtx(context.Background(), filters)
+}
+
+func (r *TypedRepo[T]) DeleteByCtx(ctx context.Context, filters map[string]interface{}) (int64, error) {
+	return r.repo.DeleteByCtx(ctx, filters)
+}
+
+func (r *TypedRepo[T]) DeleteAll() (int64, error) {
+	return r.DeleteAllCtx(context.Background())
+}
+
+func (r *TypedRepo[T]) DeleteAllCtx(ctx context.Context) (int64, error) {
+	return r.repo.DeleteAllCtx(ctx)
+}
+
+func (r *TypedRepo[T]) IncludeDeleted() *TypedRepo[T] {
+	return &TypedRepo[T]{repo: r.repo.IncludeDeleted()}
+}
+
+func typedList[T any](objects []interface{}) []*T {
+	result := make([]*T, 0, len(objects))
+	for _, object := range objects {
+		result = append(result, object.(*T))
+	}
+
+	return result
+}
@@ -0,0 +1,401 @@
+package repository
+
+// Unit of work: сессия, в пределах которой одной записи БД соответствует один объект.
+// В отличие от глобальной IdentityMap хранит только сущности, загруженные или добавленные
+// через репозитории сессии, и полностью сбрасывается при Close
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+)
+
+type identityMap struct {
+	// первый ключ - тип, второй - ID объекта
+	entitiesIds map[reflect.Type]map[int64]interface{}
+}
+
+func newIdentityMap() *identityMap {
+	return &identityMap{entitiesIds: make(map[reflect.Type]map[int64]interface{})}
+}
+
+func (u *identityMap) GetEntity(t reflect.Type, entityId int64) interface{} {
+	if _, ok := u.entitiesIds[t]; !ok {
+		return nil
+	}
+
+	return u.entitiesIds[t][entityId]
+}
+
+func (u *identityMap) HasEntityById(t reflect.Type, entityId int64) bool {
+	return u.GetEntity(t, entityId) != nil
+}
+
+func (u *identityMap) AddEntity(t reflect.Type, entityId int64, entity interface{}) {
+	if _, ok := u.entitiesIds[t]; !ok {
+		u.entitiesIds[t] = make(map[int64]interface{})
+	}
+
+	u.entitiesIds[t][entityId] = entity
+}
+
+func (u *identityMap) RemoveEntity(t reflect.Type, entityId int64) {
+	if _, ok := u.entitiesIds[t]; ok {
+		delete(u.entitiesIds[t], entityId)
+	}
+}
+
+type Session struct {
+	db       Executor
+	identity *identityMap
+	repos    map[reflect.Type]*AbstractRepo
+	// Значения колонок отслеживаемых сущностей на момент загрузки или последнего Commit
+	snapshots map[interface{}]map[string]interface{}
+	inserts   []interface{}
+	deletes   []interface{}
+	closed    bool
+}
+
+func NewSession(db Executor) *Session {
+	return &Session{
+		db:        db,
+		identity:  newIdentityMap(),
+		repos:     make(map[reflect.Type]*AbstractRepo),
+		snapshots: make(map[interface{}]map[string]interface{}),
+	}
+}
+
+// Копия репозитория, сущности которой отслеживаются сессией
+func (a *AbstractRepo) WithSession(s *Session) *AbstractRepo {
+	repo := *a
+	repo.session = s
+	s.register(&repo)
+
+	return &repo
+}
+
+// Объект для полностью загруженной записи: в сессии - ранее загруженный объект с тем же ID.
+// Для новых объектов запоминаются загруженные значения колонок
+func (a *AbstractRepo) managed(object interface{}) interface{} {
+	if object == nil {
+		return object
+	}
+
+	if _, ok := object.(trackable); ok {
+		if id, ok := a.pkValue(object); ok && id != 0 {
+			if a.session == nil || !a.session.identity.HasEntityById(a.reflectType, id) {
+				a.rememberSnapshot(object)
+			}
+		}
+	}
+
+	if a.session == nil {
+		return object
+	}
+
+	return a.session.attach(a, object)
+}
+
+func (s *Session) register(repo *AbstractRepo) {
+	if _, ok := s.repos[repo.reflectType]; !ok {
+		s.repos[repo.reflectType] = repo
+	}
+}
+
+func (s *Session) attach(repo *AbstractRepo, object interface{}) interface{} {
+	if s.closed {
+		return object
+	}
+
+	s.register(repo)
+
+	id, ok := repo.pkValue(object)
+	if !ok || id == 0 {
+		return object
+	}
+
+	if existing := s.identity.GetEntity(repo.reflectType, id); existing != nil {
+		return existing
+	}
+
+	s.identity.AddEntity(repo.reflectType, id, object)
+	s.snapshot(repo, object)
+
+	return object
+}
+
+func (s *Session) snapshot(repo *AbstractRepo, object interface{}) {
+	_, values, err := repo.qb.columnValues(repo.config, object)
+	if err != nil {
+		return
+	}
+
+	s.snapshots[object] = copyColumnValues(values)
+}
+
+// Запланировать вставку новой сущности при Commit
+func (s *Session) Persist(entity interface{}) error {
+	if s.closed {
+		return ErrSessionClosed
+	}
+
+	if _, err := s.repo(entity); err != nil {
+		return err
+	}
+
+	if _, ok := s.snapshots[entity]; ok {
+		return nil
+	}
+
+	for _, e := range s.inserts {
+		if e == entity {
+			return nil
+		}
+	}
+
+	s.inserts = append(s.inserts, entity)
+
+	return nil
+}
+
+// Запланировать удаление сущности при Commit
+func (s *Session) Remove(entity interface{}) error {
+	if s.closed {
+		return ErrSessionClosed
+	}
+
+	if _, err := s.repo(entity); err != nil {
+		return err
+	}
+
+	for i, e := range s.inserts {
+		if e == entity {
+			s.inserts = append(s.inserts[:i], s.inserts[i+1:]...)
+			return nil
+		}
+	}
+
+	for _, e := range s.deletes {
+		if e == entity {
+			return nil
+		}
+	}
+
+	s.deletes = append(s.deletes, entity)
+
+	return nil
+}
+
+// Изменённые с момента загрузки колонки сущности
+func (s *Session) dirtyColumns(entity interface{}) ([]string, error) {
+	snapshot, ok := s.snapshots[entity]
+	if !ok {
+		return nil, nil
+	}
+
+	repo, err := s.repo(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, values, err := repo.qb.columnValues(repo.config, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	return changedColumns(columns, snapshot, values), nil
+}
+
+// Выполняет в одной транзакции вставки, обновления изменённых сущностей и удаления.
+// Вставки идут от сущностей, на которые ссылаются внешние ключи, к ссылающимся, удаления - в обратном порядке
+func (s *Session) Commit(ctx context.Context) error {
+	if s.closed {
+		return ErrSessionClosed
+	}
+
+	order := s.dependencyOrder()
+
+	updates := []interface{}{}
+	previous := make(map[interface{}]map[string]interface{})
+	for entity, snapshot := range s.snapshots {
+		if s.isDeleted(entity) {
+			continue
+		}
+
+		dirty, err := s.dirtyColumns(entity)
+		if err != nil {
+			return err
+		}
+
+		if len(dirty) > 0 {
+			updates = append(updates, entity)
+			previous[entity] = snapshot
+		}
+	}
+
+	err := RunInTx(ctx, s.db, func(tx *Tx) error {
+		// Каждая попытка транзакции начинается со снимков на момент Commit
+		s.resetInserted()
+		for entity, snapshot := range previous {
+			s.snapshots[entity] = snapshot
+		}
+
+		for _, t := range order {
+			repo := s.repos[t].WithTx(tx)
+			for _, entity := range s.inserts {
+				if entityType(entity) == t {
+					if _, err := repo.SaveCtx(ctx, entity); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		for _, t := range order {
+			repo := s.repos[t].WithTx(tx)
+			for _, entity := range updates {
+				if entityType(entity) == t {
+					if err := repo.UpdateCtx(ctx, entity); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		for i := len(order) - 1; i >= 0; i-- {
+			repo := s.repos[order[i]].WithTx(tx)
+			for _, entity := range s.deletes {
+				if entityType(entity) == order[i] {
+					if err := repo.DeleteCtx(ctx, entity); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		s.resetInserted()
+		for entity, snapshot := range previous {
+			s.snapshots[entity] = snapshot
+		}
+		return err
+	}
+
+	for _, entity := range s.inserts {
+		repo, _ := s.repo(entity)
+		s.attach(repo, entity)
+	}
+
+	for _, entity := range updates {
+		repo, _ := s.repo(entity)
+		s.snapshot(repo, entity)
+	}
+
+	for _, entity := range s.deletes {
+		repo, _ := s.repo(entity)
+		if id, ok := repo.pkValue(entity); ok {
+			s.identity.RemoveEntity(repo.reflectType, id)
+		}
+		delete(s.snapshots, entity)
+	}
+
+	s.inserts = nil
+	s.deletes = nil
+
+	return nil
+}
+
+// Сбрасывает все отслеживаемые сущности и запланированные изменения
+func (s *Session) Close() {
+	s.identity = newIdentityMap()
+	s.repos = make(map[reflect.Type]*AbstractRepo)
+	s.snapshots = make(map[interface{}]map[string]interface{})
+	s.inserts = nil
+	s.deletes = nil
+	s.closed = true
+}
+
+func (s *Session) repo(entity interface{}) (*AbstractRepo, error) {
+	repo, ok := s.repos[entityType(entity)]
+	if !ok {
+		return nil, errors.New("Repository for type " + entityType(entity).String() + " is not registered in session")
+	}
+
+	return repo, nil
+}
+
+func (s *Session) isDeleted(entity interface{}) bool {
+	for _, e := range s.deletes {
+		if e == entity {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Обнуляет первичные ключи новых сущностей перед (повторной) вставкой и после отката
+func (s *Session) resetInserted() {
+	for _, entity := range s.inserts {
+		repo, _ := s.repo(entity)
+		pk := reflect.Indirect(reflect.ValueOf(entity)).FieldByName(repo.pkFieldName())
+		pk.Set(reflect.Zero(pk.Type()))
+	}
+}
+
+// Типы сущностей сессии: сначала те, на которые ссылаются связи one_to_one/many_to_one остальных
+func (s *Session) dependencyOrder() []reflect.Type {
+	types := []reflect.Type{}
+	byTable := make(map[string]reflect.Type)
+	for t, repo := range s.repos {
+		types = append(types, t)
+		byTable[repo.config.TableName] = t
+	}
+
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].String() < types[j].String()
+	})
+
+	order := []reflect.Type{}
+	visited := make(map[reflect.Type]bool)
+
+	var visit func(t reflect.Type)
+	visit = func(t reflect.Type) {
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+
+		cfg := s.repos[t].config
+		for _, relName := range cfg.RelationsArr {
+			relCfg := cfg.Relations[relName]
+			if relCfg.Type != "one_to_one" && relCfg.Type != "many_to_one" {
+				continue
+			}
+
+			if target, ok := byTable[relCfg.Target]; ok {
+				visit(target)
+			}
+		}
+
+		order = append(order, t)
+	}
+
+	for _, t := range types {
+		visit(t)
+	}
+
+	return order
+}
+
+func entityType(entity interface{}) reflect.Type {
+	t := reflect.TypeOf(entity)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type sessParent struct {
+	ID    int64  `repo:"pk"`
+	Title string `repo:""`
+}
+
+type sessChild struct {
+	ID     int64       `repo:"pk"`
+	Name   string      `repo:""`
+	Parent *sessParent `repo:"rel=many_to_one,fk=parent_id"`
+}
+
+func newSessionRepos(t *testing.T) (*fakeDb, *Session, *TypedRepo[sessParent], *TypedRepo[sessChild]) {
+	f, db := newFakeDb(t)
+
+	parents, err := NewTypedRepoFromStruct[sessParent](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	children, err := NewTypedRepoFromStruct[sessChild](db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := NewSession(db)
+
+	return f, session, parents.WithSession(session), children.WithSession(session)
+}
+
+func TestSessionIdentity(t *testing.T) {
+	f, _, parents, _ := newSessionRepos(t)
+	ctx := context.Background()
+
+	columns := []string{"id", "title"}
+	f.addRows(columns, []driver.Value{int64(1), "a"})
+	first, err := parents.FindCtx(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.addRows(columns, []driver.Value{int64(1), "changed"})
+	second, err := parents.FindCtx(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.addRows(columns, []driver.Value{int64(1), "a"}, []driver.Value{int64(2), "b"})
+	all, err := parents.FindBy(nil, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second || all[0] != first || all[1] == first {
+		t.Errorf("expected one object per record: %p %p %p", first, second, all[0])
+	}
+
+	// Загруженный объект сессии не перезаписывается повторной выборкой
+	if first.Title != "a" {
+		t.Errorf("tracked entity overwritten: %+v", first)
+	}
+}
+
+func TestSessionCommitOrder(t *testing.T) {
+	f, session, parents, children := newSessionRepos(t)
+	ctx := context.Background()
+
+	f.addRows([]string{"id", "name", "parent_id"}, []driver.Value{int64(5), "old", int64(6)})
+	f.addRows([]string{"id", "title"}, []driver.Value{int64(6), "old parent"})
+	old, err := children.FindCtx(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := &sessParent{Title: "p"}
+	child := &sessChild{Name: "c", Parent: parent}
+	for _, entity := range []interface{}{child, parent, old, old.Parent} {
+		var err error
+		if entity == old || entity == old.Parent {
+			err = session.Remove(entity)
+		} else {
+			err = session.Persist(entity)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.addRows([]string{"id"}, []driver.Value{int64(10)})
+	f.addRows([]string{"id"}, []driver.Value{int64(20)})
+	f.queries = nil
+	f.args = nil
+
+	if err := session.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	writes := []string{}
+	for _, query := range f.queries {
+		if !strings.HasPrefix(query, "SELECT") {
+			writes = append(writes, query)
+		}
+	}
+
+	expected := []string{
+		"BEGIN",
+		`INSERT INTO "sess_parents" ("title") VALUES ($1) RETURNING "id"`,
+		`INSERT INTO "sess_children" ("name", "parent_id") VALUES ($1, $2) RETURNING "id"`,
+		`DELETE FROM "sess_children" WHERE "id" = $1`,
+		`DELETE FROM "sess_parents" WHERE "id" = $1`,
+		"COMMIT",
+	}
+	if !reflect.DeepEqual(writes, expected) {
+		t.Errorf("unexpected commit queries:\n%q", writes)
+	}
+
+	if parent.ID != 10 || child.ID != 20 || !reflect.DeepEqual(f.args[2], []interface{}{"c", int64(10)}) {
+		t.Errorf("parent key must be inserted before child: %+v %+v %v", parent, child, f.args)
+	}
+
+	f.addRows([]string{"id", "title"}, []driver.Value{int64(10), "p"})
+	if found, err := parents.FindCtx(ctx, 10); err != nil || found != parent {
+		t.Errorf("inserted entity must be tracked after commit: %p, %v", found, err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	f, session, parents, _ := newSessionRepos(t)
+	ctx := context.Background()
+
+	f.addRows([]string{"id", "title"}, []driver.Value{int64(1), "a"})
+	tracked, err := parents.FindCtx(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Persist(&sessParent{Title: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	session.Close()
+
+	if len(session.snapshots) != 0 || len(session.inserts) != 0 || len(session.repos) != 0 || len(session.identity.entitiesIds) != 0 {
+		t.Errorf("session keeps entities after Close")
+	}
+
+	f.addRows([]string{"id", "title"}, []driver.Value{int64(1), "a"})
+	found, err := parents.FindCtx(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == tracked {
+		t.Errorf("closed session must not return tracked entity")
+	}
+
+	if err := session.Persist(&sessParent{}); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("expected ErrSessionClosed from Persist, got %v", err)
+	}
+	if err := session.Remove(tracked); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("expected ErrSessionClosed from Remove, got %v", err)
+	}
+	if err := session.Commit(ctx); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("expected ErrSessionClosed from Commit, got %v", err)
+	}
+	if n := len(f.queriesWithPrefix("INSERT")); n != 0 {
+		t.Errorf("closed session inserted entities")
+	}
+}